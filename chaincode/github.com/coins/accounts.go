@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Coins can only be sent to accounts of known types: the built-in ones and
+// the types registered by an admin under accountType~<type>. When the users
+// chaincode is set, user_ receivers must also exist there.
+var projectAccountType = "project_"
+var foundationAccountType = "foundation_"
+var builtInAccountTypes = []string{userAccountType, projectAccountType, foundationAccountType}
+
+// Internal accounts are only credited by the chaincode itself and can not be
+// registered or receive coins directly.
+var internalAccountTypes = []string{escrowAccountType, vestingAccountType, lockAccountType, treasuryAccountType, reserveAccountType}
+
+var accountTypePrefix = "accountType"
+var usersChaincodeKey = "usersChaincode"
+var getUserFunction = "getUserDataById"
+
+// RegisterAccountType allows coins to be sent to accounts of a new type.
+// Account types end with "_" like the built-in ones.
+func (t *CoinChain) RegisterAccountType(ctx contractapi.TransactionContextInterface, accountType string) error {
+
+	fmt.Println("register account type " + accountType)
+
+	if len(accountType) < 2 || !strings.HasSuffix(accountType, "_") || strings.Contains(accountType, mspSeparator) {
+		return newError(invalidArgumentCode, "incorrect account type %s", accountType)
+	}
+
+	if isInternalAccountType(accountType) {
+		return newError(invalidArgumentCode, "account type %s is reserved", accountType)
+	}
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(accountTypePrefix, []string{accountType})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte(accountType))
+}
+
+// UnregisterAccountType stops accepting coins for accounts of a registered
+// type. Existing balances are not touched.
+func (t *CoinChain) UnregisterAccountType(ctx contractapi.TransactionContextInterface, accountType string) error {
+
+	fmt.Println("unregister account type " + accountType)
+
+	for _, builtInType := range builtInAccountTypes {
+		if accountType == builtInType {
+			return newError(invalidArgumentCode, "built-in account type %s can not be unregistered", accountType)
+		}
+	}
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(accountTypePrefix, []string{accountType})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// AccountTypes returns all account types coins can be sent to.
+func (t *CoinChain) AccountTypes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accountTypePrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	var registered []string
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		registered = append(registered, string(entry.Value))
+	}
+	sort.Strings(registered)
+
+	return append(append([]string{}, builtInAccountTypes...), registered...), nil
+}
+
+// SetUsersChaincode turns on the check that user_ receivers exist in the
+// given users chaincode. An empty name turns the check off.
+func (t *CoinChain) SetUsersChaincode(ctx contractapi.TransactionContextInterface, chaincodeName string) error {
+
+	fmt.Println("users chaincode " + chaincodeName)
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	if len(chaincodeName) == 0 {
+		return ctx.GetStub().DelState(usersChaincodeKey)
+	}
+
+	return ctx.GetStub().PutState(usersChaincodeKey, []byte(chaincodeName))
+}
+
+// checkReceiver verifies that coins can be sent to the account.
+func checkReceiver(ctx contractapi.TransactionContextInterface, accountType string, accountId string) error {
+	err := checkAccountType(ctx, accountType)
+	if err != nil {
+		return err
+	}
+
+	if accountType != userAccountType {
+		return nil
+	}
+
+	// The users chaincode only knows users of the home organization
+	if strings.Contains(accountId, mspSeparator) {
+		return nil
+	}
+
+	chaincodeBytes, err := ctx.GetStub().GetState(usersChaincodeKey)
+	if err != nil {
+		return err
+	}
+
+	if len(chaincodeBytes) == 0 {
+		return nil
+	}
+
+	response := ctx.GetStub().InvokeChaincode(string(chaincodeBytes), [][]byte{[]byte(getUserFunction), []byte(accountId)}, "")
+	if response.Status != http.StatusOK {
+		return newError(invalidReceiverCode, "receiver %s does not exist: %s", accountId, response.Message)
+	}
+
+	return nil
+}
+
+func checkAccountType(ctx contractapi.TransactionContextInterface, accountType string) error {
+	if isInternalAccountType(accountType) {
+		return newError(invalidReceiverCode, "coins can not be sent to %s accounts", accountType)
+	}
+
+	for _, builtInType := range builtInAccountTypes {
+		if accountType == builtInType {
+			return nil
+		}
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(accountTypePrefix, []string{accountType})
+	if err != nil {
+		return err
+	}
+
+	accountTypeBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return err
+	}
+
+	if len(accountTypeBytes) == 0 {
+		return newError(invalidReceiverCode, "unknown account type %s", accountType)
+	}
+
+	return nil
+}
+
+func isInternalAccountType(accountType string) bool {
+	for _, internalType := range internalAccountTypes {
+		if accountType == internalType {
+			return true
+		}
+	}
+
+	return false
+}
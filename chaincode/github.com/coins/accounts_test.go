@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestAccountTypes(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "built-in types", caller: test.alice, function: "AccountTypes", want: `["user_","project_","foundation_"]`},
+		{name: "register by user", caller: test.alice, function: "RegisterAccountType", args: []string{"bank_"}, err: noPermissionsCode},
+		{name: "register without suffix", caller: test.admin, function: "RegisterAccountType", args: []string{"bank"}, err: invalidArgumentCode},
+		{name: "register escrow", caller: test.admin, function: "RegisterAccountType", args: []string{"escrow_"}, err: invalidArgumentCode},
+		{name: "register vesting", caller: test.admin, function: "RegisterAccountType", args: []string{"vesting_"}, err: invalidArgumentCode},
+		{name: "register htlc", caller: test.admin, function: "RegisterAccountType", args: []string{"htlc_"}, err: invalidArgumentCode},
+		{name: "register treasury", caller: test.admin, function: "RegisterAccountType", args: []string{"treasury_"}, err: invalidArgumentCode},
+		{name: "register reserve", caller: test.admin, function: "RegisterAccountType", args: []string{"reserve_"}, err: invalidArgumentCode},
+		{name: "register", caller: test.admin, function: "RegisterAccountType", args: []string{"bank_"}},
+		{name: "registered types", caller: test.alice, function: "AccountTypes", want: `["user_","project_","foundation_","bank_"]`},
+
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "10"}},
+		{name: "transfer to registered type", caller: test.minter, function: "Transfer", args: []string{"", "bank_", "b1", "2", "", "", ""}, want: `{"balance":"8"}`},
+		{name: "transfer to escrow", caller: test.minter, function: "Transfer", args: []string{"", "escrow_", "alice", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "transfer to treasury", caller: test.minter, function: "Transfer", args: []string{"", "treasury_", "treasury", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "transfer to reserve", caller: test.minter, function: "Transfer", args: []string{"", "reserve_", "exchange", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "hold for vesting account", caller: test.minter, function: "Hold", args: []string{"", "vesting_", "g1", "1", "2020-01-02T00:00:00Z"}, err: invalidReceiverCode},
+
+		{name: "unregister built-in type", caller: test.admin, function: "UnregisterAccountType", args: []string{"user_"}, err: invalidArgumentCode},
+		{name: "unregister by user", caller: test.alice, function: "UnregisterAccountType", args: []string{"bank_"}, err: noPermissionsCode},
+		{name: "unregister", caller: test.admin, function: "UnregisterAccountType", args: []string{"bank_"}},
+		{name: "transfer to unregistered type", caller: test.minter, function: "Transfer", args: []string{"", "bank_", "b1", "2", "", "", ""}, err: invalidReceiverCode},
+		{name: "balance is kept", caller: test.alice, function: "BalanceOf", args: []string{"", "bank_", "b1"}, want: `{"balance":"2"}`},
+	})
+}
+
+func TestUsersChaincode(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "10"}},
+		{name: "set by user", caller: test.alice, function: "SetUsersChaincode", args: []string{usersChaincode}, err: noPermissionsCode},
+		{name: "set", caller: test.admin, function: "SetUsersChaincode", args: []string{usersChaincode}},
+		{name: "transfer to known user", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "1", "", "", ""}, want: `{"balance":"9"}`},
+		{name: "transfer to unknown user", caller: test.minter, function: "Transfer", args: []string{"", "user_", "carol", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "batch transfer to unknown user", caller: test.minter, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"1"},{"userId":"carol","amount":"1"}]`, ""}, err: invalidReceiverCode},
+		{name: "transfer to partner user", caller: test.minter, function: "Transfer", args: []string{"", "user_", partnerMsp + mspSeparator + "carol", "1", "", "", ""}, want: `{"balance":"8"}`},
+		{name: "transfer to project", caller: test.minter, function: "Transfer", args: []string{"", "project_", "p1", "1", "", "", ""}, want: `{"balance":"7"}`},
+
+		{name: "set missing chaincode", caller: test.admin, function: "SetUsersChaincode", args: []string{"missing"}},
+		{name: "transfer with missing chaincode", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "1", "", "", ""}, err: invalidReceiverCode},
+
+		{name: "unset", caller: test.admin, function: "SetUsersChaincode", args: []string{""}},
+		{name: "transfer without check", caller: test.minter, function: "Transfer", args: []string{"", "user_", "carol", "1", "", "", ""}, want: `{"balance":"6"}`},
+	})
+}
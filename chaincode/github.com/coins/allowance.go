@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Allowances are stored per currency and owner/spender pair under
+// allowance~<currency>~<owner type>~<owner id>~<spender type>~<spender id>.
+var allowancePrefix = "allowance"
+
+type UserAllowance struct {
+	Currency string `json:"currency"`
+	Owner    string `json:"owner"`
+	Spender  string `json:"spender"`
+	Amount   string `json:"amount"`
+}
+
+// Approve allows spender to transfer up to amount coins from the current user's account.
+func (t *CoinChain) Approve(ctx contractapi.TransactionContextInterface, currency string, spenderAccountType string, spender string, amount string) (*UserAllowance, error) {
+
+	fmt.Println("spender " + spenderAccountType + spender)
+	fmt.Println("amount " + amount)
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseNonNegativeAmount(amount, currencyInfo.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	spenderAccount, err := ctx.GetStub().CreateCompositeKey(spenderAccountType, []string{spender})
+	if err != nil {
+		return nil, err
+	}
+
+	return t.setAllowance(ctx, currencyInfo, ownerAccount, spenderAccount, value)
+}
+
+// ApproveFor sets an allowance on behalf of an account which can not sign
+// transactions itself (project_, foundation_). Only the minter can call it.
+func (t *CoinChain) ApproveFor(ctx contractapi.TransactionContextInterface, currency string, ownerAccountType string, owner string, spenderAccountType string, spender string, amount string) (*UserAllowance, error) {
+
+	fmt.Println("owner " + ownerAccountType + owner)
+	fmt.Println("spender " + spenderAccountType + spender)
+	fmt.Println("amount " + amount)
+
+	if ownerAccountType == userAccountType {
+		return nil, newError(noPermissionsCode, "users must approve their own allowances")
+	}
+
+	// Coins of escrow, vesting and HTLC accounts back holds, grants and locks
+	if isInternalAccountType(ownerAccountType) && ownerAccountType != treasuryAccountType && ownerAccountType != reserveAccountType {
+		return nil, newError(noPermissionsCode, "allowances of %s accounts can not be approved", ownerAccountType)
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseNonNegativeAmount(amount, currencyInfo.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerAccount, err := ctx.GetStub().CreateCompositeKey(ownerAccountType, []string{owner})
+	if err != nil {
+		return nil, err
+	}
+
+	spenderAccount, err := ctx.GetStub().CreateCompositeKey(spenderAccountType, []string{spender})
+	if err != nil {
+		return nil, err
+	}
+
+	return t.setAllowance(ctx, currencyInfo, ownerAccount, spenderAccount, value)
+}
+
+func (t *CoinChain) IncreaseAllowance(ctx contractapi.TransactionContextInterface, currency string, spenderAccountType string, spender string, addedAmount string) (*UserAllowance, error) {
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := parseAmount(addedAmount, currencyInfo.Decimals)
+	if err != nil {
+		return nil, err
+	}
+	return t.changeAllowance(ctx, currencyInfo, spenderAccountType, spender, delta)
+}
+
+func (t *CoinChain) DecreaseAllowance(ctx contractapi.TransactionContextInterface, currency string, spenderAccountType string, spender string, subtractedAmount string) (*UserAllowance, error) {
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := parseAmount(subtractedAmount, currencyInfo.Decimals)
+	if err != nil {
+		return nil, err
+	}
+	return t.changeAllowance(ctx, currencyInfo, spenderAccountType, spender, delta.Neg(delta))
+}
+
+func (t *CoinChain) Allowance(ctx contractapi.TransactionContextInterface, currency string, ownerAccountType string, owner string, spenderAccountType string, spender string) (*UserAllowance, error) {
+
+	ownerAccount, err := ctx.GetStub().CreateCompositeKey(ownerAccountType, []string{owner})
+	if err != nil {
+		return nil, err
+	}
+
+	spenderAccount, err := ctx.GetStub().CreateCompositeKey(spenderAccountType, []string{spender})
+	if err != nil {
+		return nil, err
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := t.getAllowance(ctx, currencyInfo.Symbol, ownerAccount, spenderAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserAllowance{Currency: currencyInfo.Symbol, Owner: ownerAccount, Spender: spenderAccount, Amount: formatAmount(amount, currencyInfo.Decimals)}, nil
+}
+
+// TransferFrom moves coins from sender to receiver using the allowance the
+// sender granted to the current user. Returns the sender balance.
+func (t *CoinChain) TransferFrom(ctx contractapi.TransactionContextInterface, currency string, senderAccountType string, sender string, receiverAccountType string, receiver string, amount string) (*UserBalance, error) {
+
+	fmt.Println("sender " + senderAccountType + sender)
+	fmt.Println("receiver " + receiverAccountType + receiver)
+	fmt.Println("amount " + amount)
+
+	err := validateReceiver(receiverAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spenderAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	senderAccount, err := ctx.GetStub().CreateCompositeKey(senderAccountType, []string{sender})
+	if err != nil {
+		return nil, err
+	}
+
+	receiverAccount, err := ctx.GetStub().CreateCompositeKey(receiverAccountType, []string{receiver})
+	if err != nil {
+		return nil, err
+	}
+
+	if receiverAccount == senderAccount {
+		return nil, newError(invalidReceiverCode, "can not transfer coins to the sender")
+	}
+
+	err = checkReceiver(ctx, receiverAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	allowance, err := t.getAllowance(ctx, sheet.currency.Symbol, senderAccount, spenderAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	feePolicy, err := getFeePolicy(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fee is paid by the sender, so it is spent from the allowance too
+	fee, err := sheet.feeOf(feePolicy, senderAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	spent := new(big.Int).Add(value, fee)
+
+	if allowance.Cmp(spent) < 0 {
+		return nil, newError(insufficientAllowanceCode, "allowance exceeded")
+	}
+
+	err = checkLimits(ctx, sheet, senderAccount, []*big.Int{spent}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(senderAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = sheet.chargeFee(feePolicy, senderAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(transferEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.setAllowance(ctx, sheet.currency, senderAccount, spenderAccount, new(big.Int).Sub(allowance, spent))
+	if err != nil {
+		return nil, err
+	}
+
+	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
+	balancesResponse, err := sheet.userBalance(senderAccount)
+	if err != nil {
+		return nil, err
+	}
+	balancesResponse.Fee = formatAmount(fee, sheet.decimals)
+
+	return balancesResponse, nil
+}
+
+func (t *CoinChain) changeAllowance(ctx contractapi.TransactionContextInterface, currency *Currency, spenderAccountType string, spender string, delta *big.Int) (*UserAllowance, error) {
+
+	fmt.Println("spender " + spenderAccountType + spender)
+	fmt.Println("delta ", delta.String())
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	spenderAccount, err := ctx.GetStub().CreateCompositeKey(spenderAccountType, []string{spender})
+	if err != nil {
+		return nil, err
+	}
+
+	allowance, err := t.getAllowance(ctx, currency.Symbol, ownerAccount, spenderAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := new(big.Int).Add(allowance, delta)
+	if changed.Sign() < 0 {
+		return nil, newError(insufficientAllowanceCode, "decreased allowance below zero")
+	}
+
+	return t.setAllowance(ctx, currency, ownerAccount, spenderAccount, changed)
+}
+
+func (t *CoinChain) getAllowance(ctx contractapi.TransactionContextInterface, symbol string, owner string, spender string) (*big.Int, error) {
+
+	key, err := allowanceKey(ctx, symbol, owner, spender)
+	if err != nil {
+		return nil, err
+	}
+
+	allowanceBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return readAmount(allowanceBytes)
+}
+
+func (t *CoinChain) setAllowance(ctx contractapi.TransactionContextInterface, currency *Currency, owner string, spender string, amount *big.Int) (*UserAllowance, error) {
+
+	err := checkNotPaused(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := allowanceKey(ctx, currency.Symbol, owner, spender)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount.Sign() == 0 {
+		err = ctx.GetStub().DelState(key)
+	} else {
+		err = ctx.GetStub().PutState(key, writeAmount(amount))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserAllowance{Currency: currency.Symbol, Owner: owner, Spender: spender, Amount: formatAmount(amount, currency.Decimals)}, nil
+}
+
+func allowanceKey(ctx contractapi.TransactionContextInterface, symbol string, owner string, spender string) (string, error) {
+
+	ownerType, ownerAttributes, err := ctx.GetStub().SplitCompositeKey(owner)
+	if err != nil {
+		return "", err
+	}
+
+	spenderType, spenderAttributes, err := ctx.GetStub().SplitCompositeKey(spender)
+	if err != nil {
+		return "", err
+	}
+
+	attributes := append([]string{symbol, ownerType}, ownerAttributes...)
+	attributes = append(attributes, spenderType)
+	attributes = append(attributes, spenderAttributes...)
+
+	return ctx.GetStub().CreateCompositeKey(allowancePrefix, attributes)
+}
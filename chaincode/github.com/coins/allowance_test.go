@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestAllowances(t *testing.T) {
+	test := newCoinsTest(t)
+	alice, bob, project := account(userAccountType, "alice"), account(userAccountType, "bob"), account(projectAccountType, "p1")
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "fund alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "50", "", "", ""}},
+		{name: "fund project", caller: test.minter, function: "Transfer", args: []string{"", "project_", "p1", "10", "", "", ""}},
+
+		{name: "approve negative", caller: test.alice, function: "Approve", args: []string{"", "user_", "bob", "-1"}, err: invalidAmountCode},
+		{name: "approve", caller: test.alice, function: "Approve", args: []string{"", "user_", "bob", "20"},
+			want: `{"owner":` + jsonString(alice) + `,"spender":` + jsonString(bob) + `,"amount":"20"}`},
+		{name: "increase", caller: test.alice, function: "IncreaseAllowance", args: []string{"", "user_", "bob", "5"}, want: `{"amount":"25"}`},
+		{name: "decrease below zero", caller: test.alice, function: "DecreaseAllowance", args: []string{"", "user_", "bob", "30"}, err: insufficientAllowanceCode},
+		{name: "decrease", caller: test.alice, function: "DecreaseAllowance", args: []string{"", "user_", "bob", "10"}, want: `{"amount":"15"}`},
+		{name: "allowance", caller: test.carol, function: "Allowance", args: []string{"", "user_", "alice", "user_", "bob"}, want: `{"amount":"15"}`},
+
+		{name: "transfer from over allowance", caller: test.bob, function: "TransferFrom", args: []string{"", "user_", "alice", "user_", "carol", "20"}, err: insufficientAllowanceCode},
+		{name: "transfer from to the sender", caller: test.bob, function: "TransferFrom", args: []string{"", "user_", "alice", "user_", "alice", "1"}, err: invalidReceiverCode},
+		{name: "transfer from without allowance", caller: test.carol, function: "TransferFrom", args: []string{"", "user_", "alice", "user_", "carol", "1"}, err: insufficientAllowanceCode},
+		{name: "transfer from", caller: test.bob, function: "TransferFrom", args: []string{"", "user_", "alice", "user_", "carol", "10"}, want: `{"userId":` + jsonString(alice) + `,"balance":"40","fee":"0"}`, event: transferEventName},
+		{name: "allowance spent", caller: test.bob, function: "Allowance", args: []string{"", "user_", "alice", "user_", "bob"}, want: `{"amount":"5"}`},
+
+		{name: "approve for by user", caller: test.alice, function: "ApproveFor", args: []string{"", "project_", "p1", "user_", "bob", "3"}, err: noPermissionsCode},
+		{name: "approve for user account", caller: test.admin, function: "ApproveFor", args: []string{"", "user_", "alice", "user_", "bob", "3"}, err: noPermissionsCode},
+		{name: "approve for escrow account", caller: test.admin, function: "ApproveFor", args: []string{"", "escrow_", "h1", "user_", "bob", "3"}, err: noPermissionsCode},
+		{name: "approve for htlc account", caller: test.admin, function: "ApproveFor", args: []string{"", "htlc_", "l1", "user_", "bob", "3"}, err: noPermissionsCode},
+		{name: "approve for treasury account", caller: test.admin, function: "ApproveFor", args: []string{"", "treasury_", "main", "user_", "bob", "3"}, want: `{"amount":"3"}`},
+		{name: "approve for", caller: test.admin, function: "ApproveFor", args: []string{"", "project_", "p1", "user_", "bob", "3"},
+			want: `{"owner":` + jsonString(project) + `,"spender":` + jsonString(bob) + `,"amount":"3"}`},
+		{name: "transfer from project", caller: test.bob, function: "TransferFrom", args: []string{"", "project_", "p1", "user_", "carol", "3"}, want: `{"balance":"7"}`},
+		{name: "project allowance spent", caller: test.bob, function: "Allowance", args: []string{"", "project_", "p1", "user_", "bob"}, want: `{"amount":"0"}`},
+		{name: "carol received", caller: test.carol, function: "BalanceOf", args: []string{"", "user_", "carol"}, want: `{"balance":"13"}`},
+	})
+}
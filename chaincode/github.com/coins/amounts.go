@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/big"
+
+	"github.com/helper"
+)
+
+// Amounts are passed to and returned from transactions as decimal strings
+// ("12.5") and kept in the state as integer strings of base units, where one
+// coin is 10^decimals base units. Decimals are set per currency.
+
+// parseAmount converts a positive decimal amount into base units.
+func parseAmount(amount string, decimals int) (*big.Int, error) {
+	value, err := parseNonNegativeAmount(amount, decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	if value.Sign() == 0 {
+		return nil, newError(invalidAmountCode, "incorrect amount")
+	}
+
+	return value, nil
+}
+
+// parseNonNegativeAmount converts a decimal amount which may be zero into base units.
+func parseNonNegativeAmount(amount string, decimals int) (*big.Int, error) {
+	value, err := helper.ParseAmount(amount, decimals)
+	if err != nil {
+		return nil, newError(invalidAmountCode, "%s", err.Error())
+	}
+
+	return value, nil
+}
+
+func formatAmount(amount *big.Int, decimals int) string {
+	return helper.FormatAmount(amount, decimals)
+}
+
+// readAmount parses base units stored in the state, missing value is zero.
+func readAmount(amountBytes []byte) (*big.Int, error) {
+	if len(amountBytes) == 0 {
+		return new(big.Int), nil
+	}
+
+	amount, ok := new(big.Int).SetString(string(amountBytes), 10)
+	if !ok {
+		return nil, newError(invalidStateCode, "incorrect stored amount %q", string(amountBytes))
+	}
+
+	return amount, nil
+}
+
+func writeAmount(amount *big.Int) []byte {
+	return []byte(amount.String())
+}
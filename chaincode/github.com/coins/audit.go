@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Coins are only created by mint and destroyed by burn, so the balances of a
+// currency, including coins on escrow_, vesting_ and htlc_ accounts, always
+// add up to its total supply. In debug mode every save of a balance sheet
+// checks this before the transaction is committed.
+var debugModeKey = "debugMode"
+
+type AccountTypeAudit struct {
+	AccountType string `json:"accountType"`
+	Accounts    int    `json:"accounts"`
+	Balance     string `json:"balance"`
+	Expected    string `json:"expected,omitempty" metadata:"expected,optional"`
+}
+
+// AccountDiscrepancy is an escrow_, vesting_ or htlc_ account whose balance
+// differs from the holds, grants or locks it backs.
+type AccountDiscrepancy struct {
+	AccountType string `json:"accountType"`
+	AccountId   string `json:"accountId"`
+	Balance     string `json:"balance"`
+	Expected    string `json:"expected"`
+}
+
+type SupplyAudit struct {
+	Currency      string                `json:"currency"`
+	TotalSupply   string                `json:"totalSupply"`
+	Balances      string                `json:"balances"`
+	Difference    string                `json:"difference"`
+	Balanced      bool                  `json:"balanced"`
+	AccountTypes  []*AccountTypeAudit   `json:"accountTypes"`
+	Discrepancies []*AccountDiscrepancy `json:"discrepancies"`
+	DebugMode     bool                  `json:"debugMode"`
+}
+
+// AuditSupply sums all balances of the currency and compares them with its
+// total supply (minted minus burnt coins). Balances of escrow_, vesting_ and
+// htlc_ accounts are also compared with the holds, grants and locks they
+// back. Only auditors can run it.
+func (t *CoinChain) AuditSupply(ctx contractapi.TransactionContextInterface, currency string) (*SupplyAudit, error) {
+
+	_, err := checkRole(ctx, auditorRole)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	totalSupply, err := getTotalSupply(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := sheet.expectedBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	debugMode, err := isDebugMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &SupplyAudit{
+		Currency:      sheet.currency.Symbol,
+		TotalSupply:   formatAmount(totalSupply, sheet.decimals),
+		AccountTypes:  []*AccountTypeAudit{},
+		Discrepancies: []*AccountDiscrepancy{},
+		DebugMode:     debugMode,
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(balancePrefix, []string{sheet.currency.Symbol})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	total := new(big.Int)
+	typeBalances := make(map[string]*big.Int)
+	typeAccounts := make(map[string]int)
+	seen := make(map[string]bool)
+
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := readAmount(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		accountType, accountId := attributes[1], attributes[2]
+
+		total.Add(total, balance)
+		if typeBalances[accountType] == nil {
+			typeBalances[accountType] = new(big.Int)
+		}
+		typeBalances[accountType].Add(typeBalances[accountType], balance)
+		typeAccounts[accountType]++
+
+		account, err := ctx.GetStub().CreateCompositeKey(accountType, []string{accountId})
+		if err != nil {
+			return nil, err
+		}
+
+		if expectedBalance, ok := expected[account]; ok {
+			seen[account] = true
+			if balance.Cmp(expectedBalance) != 0 {
+				audit.Discrepancies = append(audit.Discrepancies, &AccountDiscrepancy{
+					AccountType: accountType,
+					AccountId:   accountId,
+					Balance:     formatAmount(balance, sheet.decimals),
+					Expected:    formatAmount(expectedBalance, sheet.decimals),
+				})
+			}
+		}
+	}
+
+	// Holds, grants and locks whose account has no balance at all
+	missing := []string{}
+	for account := range expected {
+		if !seen[account] {
+			missing = append(missing, account)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, account := range missing {
+		if expected[account].Sign() == 0 {
+			continue
+		}
+
+		accountType, accountId, err := splitAccount(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+
+		audit.Discrepancies = append(audit.Discrepancies, &AccountDiscrepancy{
+			AccountType: accountType,
+			AccountId:   accountId,
+			Balance:     formatAmount(new(big.Int), sheet.decimals),
+			Expected:    formatAmount(expected[account], sheet.decimals),
+		})
+	}
+
+	// Expected totals of account types backed by records
+	typeExpected := make(map[string]*big.Int)
+	for _, accountType := range []string{escrowAccountType, vestingAccountType, lockAccountType} {
+		typeExpected[accountType] = new(big.Int)
+	}
+
+	for account, expectedBalance := range expected {
+		accountType, _, err := splitAccount(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+		typeExpected[accountType].Add(typeExpected[accountType], expectedBalance)
+	}
+
+	accountTypes := make([]string, 0, len(typeBalances))
+	for accountType := range typeBalances {
+		accountTypes = append(accountTypes, accountType)
+	}
+	for accountType, expectedBalance := range typeExpected {
+		if typeBalances[accountType] == nil && expectedBalance.Sign() != 0 {
+			typeBalances[accountType] = new(big.Int)
+			accountTypes = append(accountTypes, accountType)
+		}
+	}
+	sort.Strings(accountTypes)
+
+	for _, accountType := range accountTypes {
+		typeAudit := &AccountTypeAudit{
+			AccountType: accountType,
+			Accounts:    typeAccounts[accountType],
+			Balance:     formatAmount(typeBalances[accountType], sheet.decimals),
+		}
+
+		if expectedBalance, ok := typeExpected[accountType]; ok {
+			typeAudit.Expected = formatAmount(expectedBalance, sheet.decimals)
+		}
+
+		audit.AccountTypes = append(audit.AccountTypes, typeAudit)
+	}
+
+	difference := new(big.Int).Sub(total, totalSupply)
+
+	audit.Balances = formatAmount(total, sheet.decimals)
+	audit.Difference = formatAmount(difference, sheet.decimals)
+	audit.Balanced = difference.Sign() == 0 && len(audit.Discrepancies) == 0
+
+	return audit, nil
+}
+
+// SetDebugMode turns on the supply check of every transaction which moves
+// coins. It scans all balances of the currency, so keep it off in production.
+func (t *CoinChain) SetDebugMode(ctx contractapi.TransactionContextInterface, enabled bool) error {
+
+	fmt.Println("debug mode ", enabled)
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		return ctx.GetStub().DelState(debugModeKey)
+	}
+
+	return ctx.GetStub().PutState(debugModeKey, []byte("true"))
+}
+
+// checkSupply fails if the balances of the sheet currency would not add up
+// to its total supply after the changes of the sheet are saved.
+func (s *balanceSheet) checkSupply() error {
+	debugMode, err := isDebugMode(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	if !debugMode || (len(s.changed) == 0 && s.supply.Sign() == 0) {
+		return nil
+	}
+
+	totalSupply, err := getTotalSupply(s.ctx, s.currency.Symbol)
+	if err != nil {
+		return err
+	}
+
+	iterator, err := s.ctx.GetStub().GetStateByPartialCompositeKey(balancePrefix, []string{s.currency.Symbol})
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	total := new(big.Int)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+
+		balance, err := readAmount(entry.Value)
+		if err != nil {
+			return err
+		}
+		total.Add(total, balance)
+	}
+
+	// GetState returns committed balances, add the changes of the sheet
+	for account, balance := range s.balances {
+		total.Add(total, balance)
+		total.Sub(total, s.original[account])
+	}
+
+	supply := new(big.Int).Add(totalSupply, s.supply)
+
+	if total.Cmp(supply) != 0 {
+		return newError(invalidStateCode, "supply invariant violated: balances %s, total supply %s",
+			formatAmount(total, s.decimals), formatAmount(supply, s.decimals))
+	}
+
+	return nil
+}
+
+// expectedBalances returns the balances escrow_, vesting_ and htlc_ accounts
+// of the sheet currency must have according to active holds, grants and locks.
+func (s *balanceSheet) expectedBalances() (map[string]*big.Int, error) {
+	expected := make(map[string]*big.Int)
+
+	holdsIterator, err := s.ctx.GetStub().GetStateByPartialCompositeKey(holdPrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer holdsIterator.Close()
+
+	for holdsIterator.HasNext() {
+		entry, err := holdsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		hold := new(Hold)
+		err = json.Unmarshal(entry.Value, hold)
+		if err != nil {
+			return nil, err
+		}
+
+		if hold.Status != heldStatus {
+			continue
+		}
+
+		err = s.expect(expected, escrowAccountType, hold.Payer, hold.Currency, hold.Amount, "0")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(hold.Fee) != 0 {
+			err = s.expect(expected, escrowAccountType, hold.Payer, hold.Currency, hold.Fee, "0")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	grantsIterator, err := s.ctx.GetStub().GetStateByPartialCompositeKey(grantPrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer grantsIterator.Close()
+
+	for grantsIterator.HasNext() {
+		entry, err := grantsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		grant := new(Grant)
+		err = json.Unmarshal(entry.Value, grant)
+		if err != nil {
+			return nil, err
+		}
+
+		// Claimed coins already left the vesting account
+		err = s.expect(expected, vestingAccountType, grant.Id, grant.Currency, grant.Amount, grant.Claimed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	locksIterator, err := s.ctx.GetStub().GetStateByPartialCompositeKey(lockPrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer locksIterator.Close()
+
+	for locksIterator.HasNext() {
+		entry, err := locksIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		lock := new(Lock)
+		err = json.Unmarshal(entry.Value, lock)
+		if err != nil {
+			return nil, err
+		}
+
+		if lock.Status != lockedStatus {
+			continue
+		}
+
+		err = s.expect(expected, lockAccountType, lock.Id, lock.Currency, lock.Amount, "0")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return expected, nil
+}
+
+// expect adds amount minus spent to the expected balance of the account if
+// the record is in the sheet currency.
+func (s *balanceSheet) expect(expected map[string]*big.Int, accountType string, accountId string, currency string, amount string, spent string) error {
+	if currency != s.currency.Symbol {
+		return nil
+	}
+
+	value, err := parseNonNegativeAmount(amount, s.decimals)
+	if err != nil {
+		return err
+	}
+
+	spentValue, err := parseNonNegativeAmount(spent, s.decimals)
+	if err != nil {
+		return err
+	}
+
+	account, err := s.ctx.GetStub().CreateCompositeKey(accountType, []string{accountId})
+	if err != nil {
+		return err
+	}
+
+	if expected[account] == nil {
+		expected[account] = new(big.Int)
+	}
+	expected[account].Add(expected[account], value.Sub(value, spentValue))
+	return nil
+}
+
+func isDebugMode(ctx contractapi.TransactionContextInterface) (bool, error) {
+	debugBytes, err := ctx.GetStub().GetState(debugModeKey)
+	if err != nil {
+		return false, err
+	}
+
+	return len(debugBytes) != 0, nil
+}
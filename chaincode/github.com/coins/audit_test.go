@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func TestAuditSupply(t *testing.T) {
+	test := newCoinsTest(t)
+
+	// corrupt sets a balance bypassing the chaincode, as a faulty upgrade might
+	corrupt := func(accountType string, accountId string, amount string) func(t *testing.T, payload []byte) {
+		return func(t *testing.T, payload []byte) {
+			key, err := shim.CreateCompositeKey(balancePrefix, []string{"SJ", accountType, accountId})
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.network.setState(coinsChaincode, key, []byte(amount))
+		}
+	}
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "50", "", "", ""}},
+		{name: "hold", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "10", "2020-01-02T00:00:00Z"}},
+		{name: "grant", caller: test.minter, function: "CreateGrant", args: []string{"", "bob", "20", "2020-01-01T00:00:00Z", "0", "3600"}},
+
+		{name: "audit by user", caller: test.alice, function: "AuditSupply", args: []string{""}, err: noPermissionsCode},
+		{name: "audit", caller: test.auditor, function: "AuditSupply", args: []string{""},
+			want: `{"currency":"SJ","totalSupply":"100","balances":"100","difference":"0","balanced":true,"accountTypes":[` +
+				`{"accountType":"escrow_","accounts":1,"balance":"10","expected":"10"},` +
+				`{"accountType":"user_","accounts":2,"balance":"70"},` +
+				`{"accountType":"vesting_","accounts":1,"balance":"20","expected":"20"}],"discrepancies":[],"debugMode":false}`},
+
+		{name: "debug mode by user", caller: test.alice, function: "SetDebugMode", args: []string{"true"}, err: noPermissionsCode},
+		{name: "debug mode on", caller: test.admin, function: "SetDebugMode", args: []string{"true"}},
+		{name: "transfer in debug mode", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, want: `{"balance":"39"}`},
+		{name: "audit in debug mode", caller: test.auditor, function: "AuditSupply", args: []string{""}, want: `{"balanced":true,"debugMode":true}`,
+			check: corrupt(userAccountType, "carol", "500")},
+		{name: "transfer with broken supply", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, err: invalidStateCode},
+		{name: "audit broken supply", caller: test.auditor, function: "AuditSupply", args: []string{""}, want: `{"balances":"105","difference":"5","balanced":false,"discrepancies":[]}`,
+			check: corrupt(escrowAccountType, "alice", "500")},
+		{name: "audit broken escrow", caller: test.auditor, function: "AuditSupply", args: []string{""},
+			want: `{"difference":"0","balanced":false,"discrepancies":[{"accountType":"escrow_","accountId":"alice","balance":"5","expected":"10"}]}`},
+
+		{name: "debug mode off", caller: test.admin, function: "SetDebugMode", args: []string{"false"}},
+		{name: "transfer without debug mode", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, want: `{"balance":"38"}`},
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every account balance is stored under its own composite key
+// balance~<currency>~<account type>~<account id>, so transactions touching
+// unrelated accounts do not conflict with each other.
+var balancePrefix = "balance"
+
+// balanceSheet caches balances read and written during one transaction.
+// Fabric does not return uncommitted writes from GetState, so all balance
+// changes of a transaction must go through the same sheet and be saved once.
+// A sheet holds balances of a single currency.
+type balanceSheet struct {
+	ctx      contractapi.TransactionContextInterface
+	currency *Currency
+	decimals int
+	balances map[string]*big.Int
+	original map[string]*big.Int // balances as read from the ledger
+	changed  map[string]bool
+	frozen   map[string]bool
+	supply   *big.Int            // change of the total supply made by mint and burn
+	outflow  map[string]*big.Int // outflow of user_ senders recorded by checkLimits
+
+	movements []movement
+	journaled int // number of movements already written to the journal
+}
+
+// newBalanceSheet returns a sheet of the currency with the symbol, of the
+// default currency if the symbol is empty.
+func newBalanceSheet(ctx contractapi.TransactionContextInterface, symbol string) (*balanceSheet, error) {
+	currency, err := getCurrency(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	return &balanceSheet{
+		ctx:      ctx,
+		currency: currency,
+		decimals: currency.Decimals,
+		balances: make(map[string]*big.Int),
+		original: make(map[string]*big.Int),
+		changed:  make(map[string]bool),
+		frozen:   make(map[string]bool),
+		supply:   new(big.Int),
+		outflow:  make(map[string]*big.Int),
+	}, nil
+}
+
+// balanceOf returns the balance of account, where account is a composite key
+// built from the account type and account id.
+func (s *balanceSheet) balanceOf(account string) (*big.Int, error) {
+	if balance, ok := s.balances[account]; ok {
+		return balance, nil
+	}
+
+	key, err := balanceKey(s.ctx, s.currency.Symbol, account)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceBytes, err := s.ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := readAmount(balanceBytes)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted balance of %s: %s", key, err.Error())
+	}
+
+	s.balances[account] = balance
+	s.original[account] = balance
+	return balance, nil
+}
+
+// add changes the balance of account by amount, which is negative for debits.
+func (s *balanceSheet) add(account string, amount *big.Int) error {
+	balance, err := s.balanceOf(account)
+	if err != nil {
+		return err
+	}
+
+	s.balances[account] = new(big.Int).Add(balance, amount)
+	s.changed[account] = true
+	return nil
+}
+
+func (s *balanceSheet) transfer(from string, to string, amount *big.Int) error {
+	return s.transferWithMemo(from, to, amount, "", "")
+}
+
+// transferWithMemo is transfer with a memo and an external reference kept in
+// the event and the history of the movement.
+func (s *balanceSheet) transferWithMemo(from string, to string, amount *big.Int, memo string, reference string) error {
+	err := s.checkNotFrozen(from)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkNotFrozen(to)
+	if err != nil {
+		return err
+	}
+
+	balance, err := s.balanceOf(from)
+	if err != nil {
+		return err
+	}
+
+	if balance.Cmp(amount) < 0 {
+		return newError(insufficientFundsCode, "not enough coins")
+	}
+
+	err = s.add(from, new(big.Int).Neg(amount))
+	if err != nil {
+		return err
+	}
+
+	err = s.add(to, amount)
+	if err != nil {
+		return err
+	}
+
+	return s.record(from, to, amount, memo, reference)
+}
+
+func (s *balanceSheet) mint(to string, amount *big.Int) error {
+	err := s.add(to, amount)
+	if err != nil {
+		return err
+	}
+
+	s.supply = new(big.Int).Add(s.supply, amount)
+	return s.record("", to, amount, "", "")
+}
+
+func (s *balanceSheet) burn(from string, amount *big.Int) error {
+	err := s.checkNotFrozen(from)
+	if err != nil {
+		return err
+	}
+
+	balance, err := s.balanceOf(from)
+	if err != nil {
+		return err
+	}
+
+	if balance.Cmp(amount) < 0 {
+		return newError(insufficientFundsCode, "not enough coins")
+	}
+
+	err = s.add(from, new(big.Int).Neg(amount))
+	if err != nil {
+		return err
+	}
+
+	s.supply = new(big.Int).Sub(s.supply, amount)
+	return s.record(from, "", amount, "", "")
+}
+
+func (s *balanceSheet) save() error {
+	err := checkNotPaused(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkSupply()
+	if err != nil {
+		return err
+	}
+
+	accounts := make([]string, 0, len(s.changed))
+	for account := range s.changed {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	for _, account := range accounts {
+		key, err := balanceKey(s.ctx, s.currency.Symbol, account)
+		if err != nil {
+			return err
+		}
+
+		err = s.ctx.GetStub().PutState(key, writeAmount(s.balances[account]))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.saveJournal()
+	if err != nil {
+		return err
+	}
+
+	if s.supply.Sign() != 0 {
+		totalSupply, err := getTotalSupply(s.ctx, s.currency.Symbol)
+		if err != nil {
+			return err
+		}
+
+		key, err := s.ctx.GetStub().CreateCompositeKey(supplyPrefix, []string{s.currency.Symbol})
+		if err != nil {
+			return err
+		}
+
+		err = s.ctx.GetStub().PutState(key, writeAmount(new(big.Int).Add(totalSupply, s.supply)))
+		if err != nil {
+			return err
+		}
+	}
+
+	s.changed = make(map[string]bool)
+	s.supply = new(big.Int)
+	s.journaled = len(s.movements)
+	return nil
+}
+
+// userBalance returns the balance of account as a transaction response.
+func (s *balanceSheet) userBalance(account string) (*UserBalance, error) {
+	balance, err := s.balanceOf(account)
+	if err != nil {
+		return nil, err
+	}
+
+	balancesResponse := new(UserBalance)
+	balancesResponse.UserId = account
+	balancesResponse.Balance = formatAmount(balance, s.decimals)
+
+	return balancesResponse, nil
+}
+
+// userBalanceWithHolds is userBalance with the amount of coins on hold.
+func (s *balanceSheet) userBalanceWithHolds(account string) (*UserBalance, error) {
+	balancesResponse, err := s.userBalance(account)
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := s.heldBalance(account)
+	if err != nil {
+		return nil, err
+	}
+
+	balancesResponse.Held = formatAmount(held, s.decimals)
+
+	return balancesResponse, nil
+}
+
+func getTotalSupply(ctx contractapi.TransactionContextInterface, symbol string) (*big.Int, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(supplyPrefix, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	supplyBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return readAmount(supplyBytes)
+}
+
+// balanceKey converts an account composite key (account type + id) into the
+// state key its balance in the currency is stored under.
+func balanceKey(ctx contractapi.TransactionContextInterface, symbol string, account string) (string, error) {
+	accountType, attributes, err := ctx.GetStub().SplitCompositeKey(account)
+	if err != nil {
+		return "", err
+	}
+
+	return ctx.GetStub().CreateCompositeKey(balancePrefix, append([]string{symbol, accountType}, attributes...))
+}
+
+// splitAccount returns the account type and account id of an account composite key.
+func splitAccount(ctx contractapi.TransactionContextInterface, account string) (string, string, error) {
+	accountType, attributes, err := ctx.GetStub().SplitCompositeKey(account)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(attributes) != 1 {
+		return "", "", fmt.Errorf("invalid account %s", account)
+	}
+
+	return accountType, attributes[0], nil
+}
@@ -1,108 +1,248 @@
 package main
 
 import (
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
-	"errors"
 	"fmt"
+	"github.com/helper"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
-	"log"
-	"reflect"
-	"regexp"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type CoinChain struct {
 	contractapi.Contract
 }
 
+// TransferRequest amounts are decimal strings, JSON numbers are accepted too.
 type TransferRequest struct {
-	UserId string `json:"userId"`
-	Amount int    `json:"amount"`
+	UserId    string      `json:"userId"`
+	Amount    json.Number `json:"amount"`
+	Memo      string      `json:"memo,omitempty"`
+	Reference string      `json:"reference,omitempty"`
 }
 
+// UserBalance holds the spendable balance. BalanceOf also returns coins on
+// hold, transfers return the charged fee.
 type UserBalance struct {
 	UserId  string `json:"userId"`
-	Balance int    `json:"balance"`
+	Balance string `json:"balance"`
+	Held    string `json:"held,omitempty" metadata:"held,optional"`
+	Fee     string `json:"fee,omitempty" metadata:"fee,optional"`
 }
 
-var currencyName string
+type AccountBalance struct {
+	AccountType string `json:"accountType"`
+	AccountId   string `json:"accountId"`
+	Balance     string `json:"balance"`
+}
+
+type BalancesPage struct {
+	Balances []*AccountBalance `json:"balances"`
+	Bookmark string            `json:"bookmark"`
+}
+
+type TokenInfo struct {
+	Currency    string `json:"currency"`
+	Name        string `json:"name"`
+	Minter      string `json:"minter"`
+	TotalSupply string `json:"totalSupply"`
+	Decimals    int    `json:"decimals"`
+}
+
+type BurnRecord struct {
+	Currency string `json:"currency"`
+	Account  string `json:"account"`
+	Amount   string `json:"amount"`
+	Reason   string `json:"reason"`
+	BurntBy  string `json:"burntBy"`
+}
 
 var minterKey = "minter"
-var balancesKey = "balances"
-var currencyKey = "currency"
+var balancesKey = "balances" // legacy map with all balances, see MigrateBalances
+var currencyKey = "currency" // symbol of the default currency
+var homeMspKey = "homeMsp"
+var burnPrefix = "burn"
 
 var userAccountType = "user_"
 
-//For TransferFrom
-var txBalancesMap map[string]int
-var lastTxId string
+var userIdAttribute = "coins.userId"
+var mspSeparator = "::"
 
 func (t *CoinChain) InitLedger(ctx contractapi.TransactionContextInterface) (string, error) {
 
 	/* args
 	0 - minter ID
-	1 - Currency name
+	1 - Symbol of the default currency
+	2 - Decimals (optional, 0 by default)
 	*/
 
 	_, args := ctx.GetStub().GetFunctionAndParameters()
 
-	if len(args) != 2 {
-		return "-1", fmt.Errorf("incorrect number of arguments. Expected 2, was %d", len(args))
+	if len(args) != 2 && len(args) != 3 {
+		return "-1", newError(invalidArgumentCode, "incorrect number of arguments. Expected 2 or 3, was %d", len(args))
 	}
 
-	currencyName = args[1]
+	symbol := args[1]
 
-	fmt.Println("_____ Init " + currencyName + "_____")
-
-	currentUserId, err := getCurrentUserId(ctx)
+	err := validateSymbol(symbol)
 	if err != nil {
-		return currencyName, err
+		return "-1", err
+	}
+
+	decimals := 0
+	if len(args) == 3 {
+		decimals, err = strconv.Atoi(args[2])
+		if err != nil || decimals < 0 || decimals > helper.MaxDecimals {
+			return "-1", newError(invalidArgumentCode, "incorrect decimals %s", args[2])
+		}
 	}
 
-	err = ctx.GetStub().PutState(currencyKey, []byte(currencyName))
+	fmt.Println("_____ Init " + symbol + "_____")
+
+	currentSymbolBytes, err := ctx.GetStub().GetState(currencyKey)
 	if err != nil {
-		return currencyName, err
+		return symbol, err
 	}
 
-	fmt.Println("minter ID: " + args[0])
+	// Once the ledger is initialized only an admin (the minter holds every
+	// role) can run it again, e.g. to change the decimals before coins are
+	// minted. The minter is changed by TransferMinter and AcceptMinter only.
+	if len(currentSymbolBytes) != 0 {
+		_, err = checkRole(ctx, adminRole)
+		if err != nil {
+			return symbol, err
+		}
 
-	minterBytes := []byte(args[0])
+		minterBytes, err := ctx.GetStub().GetState(minterKey)
+		if err != nil {
+			return symbol, err
+		}
 
-	err = ctx.GetStub().PutState(minterKey, minterBytes)
+		if string(minterBytes) != args[0] {
+			return symbol, newError(invalidStateCode, "minter %s can not be changed by InitLedger, use TransferMinter", string(minterBytes))
+		}
+	}
+
+	// Balances of the default currency would be lost
+	if len(currentSymbolBytes) != 0 && string(currentSymbolBytes) != symbol {
+		return symbol, newError(invalidStateCode, "default currency %s can not be changed", string(currentSymbolBytes))
+	}
+
+	err = ctx.GetStub().PutState(currencyKey, []byte(symbol))
 	if err != nil {
-		return currencyName, err
+		return symbol, err
 	}
 
-	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	homeMspBytes, err := ctx.GetStub().GetState(homeMspKey)
 	if err != nil {
-		return currencyName, err
+		return symbol, err
 	}
 
-	fmt.Println("currentUserAccount: " + currentUserAccount)
+	// Users of this MSP are identified by their user ID only, see
+	// getCurrentUserId. Changing it would rename every user.
+	if len(homeMspBytes) == 0 {
+		mspId, err := ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return symbol, err
+		}
 
-	balancesMap := t.getMap(ctx, balancesKey)
+		fmt.Println("home MSP: " + mspId)
 
-	if len(balancesMap) == 0 {
-		balancesMap = map[string]int{currentUserAccount: 0}
-		err = t.saveMap(ctx, balancesKey, balancesMap)
+		err = ctx.GetStub().PutState(homeMspKey, []byte(mspId))
 		if err != nil {
-			return currencyName, err
+			return symbol, err
 		}
 	}
 
-	return currencyName, nil
+	fmt.Println("minter ID: " + args[0])
+
+	err = ctx.GetStub().PutState(minterKey, []byte(args[0]))
+	if err != nil {
+		return symbol, err
+	}
+
+	fmt.Println("decimals: ", decimals)
+
+	currency := &Currency{Symbol: symbol, Name: symbol, Minter: args[0], Decimals: decimals}
+
+	currencyRecordKey, err := ctx.GetStub().CreateCompositeKey(currencyPrefix, []string{symbol})
+	if err != nil {
+		return symbol, err
+	}
+
+	currencyBytes, err := ctx.GetStub().GetState(currencyRecordKey)
+	if err != nil {
+		return symbol, err
+	}
+
+	if len(currencyBytes) != 0 {
+		err = json.Unmarshal(currencyBytes, currency)
+		if err != nil {
+			return symbol, err
+		}
+	}
+
+	totalSupply, err := getTotalSupply(ctx, symbol)
+	if err != nil {
+		return symbol, err
+	}
+
+	// Balances are kept in base units, changing decimals would rescale them
+	if decimals != currency.Decimals && totalSupply.Sign() != 0 {
+		return symbol, newError(invalidStateCode, "decimals can not be changed after coins were minted")
+	}
+
+	currency.Minter = args[0]
+	currency.Decimals = decimals
+
+	err = saveCurrency(ctx, currency)
+	if err != nil {
+		return symbol, err
+	}
+
+	return symbol, nil
 }
 
-func (t *CoinChain) Transfer(ctx contractapi.TransactionContextInterface, receiverAccountType string, receiver string, amount int) (*UserBalance, error) {
+// Transfer moves coins from the current user to the receiver. A retried
+// transfer with the same non-empty requestId returns the first result. Memo
+// and reference (order ID, invoice number) are optional.
+func (t *CoinChain) Transfer(ctx contractapi.TransactionContextInterface, currency string, receiverAccountType string, receiver string, amount string, requestId string, memo string, reference string) (*UserBalance, error) {
 
 	fmt.Println("accountType: " + receiverAccountType)
 	fmt.Println("receiver " + receiver)
-	fmt.Println("amount " + string(amount))
+	fmt.Println("amount " + amount)
+	fmt.Println("memo " + memo + ", reference " + reference)
+
+	request, err := findRequest(ctx, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	if request != nil {
+		return request.Result, nil
+	}
 
-	if amount == 0 {
-		return nil, errors.New("incorrect amount")
+	err = validateReceiver(receiverAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateMemo(memo, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
 	}
 
 	currentUserId, err := getCurrentUserId(ctx)
@@ -124,44 +264,84 @@ func (t *CoinChain) Transfer(ctx contractapi.TransactionContextInterface, receiv
 
 	fmt.Println("receiverAccount " + receiverAccount)
 
-	balancesMap := t.getMap(ctx, balancesKey)
+	if receiverAccount == currentUserAccount {
+		return nil, newError(invalidReceiverCode, "can not transfer coins to yourself")
+	}
+
+	err = checkReceiver(ctx, receiverAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	feePolicy, err := getFeePolicy(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
+	}
 
-	if balancesMap[currentUserAccount] < amount {
-		return nil, errors.New("not enough coins")
+	// The fee counts toward the limits of the sender
+	fee, err := sheet.feeOf(feePolicy, currentUserAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
 	}
 
-	balancesMap[currentUserAccount] -= amount
-	balancesMap[receiverAccount] += amount
+	err = checkLimits(ctx, sheet, currentUserAccount, []*big.Int{new(big.Int).Add(value, fee)}, false)
+	if err != nil {
+		return nil, err
+	}
 
-	err = t.saveMap(ctx, balancesKey, balancesMap)
+	err = sheet.transferWithMemo(currentUserAccount, receiverAccount, value, memo, reference)
 	if err != nil {
 		return nil, err
 	}
 
-	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
-	balancesResponse := new(UserBalance)
-	balancesResponse.UserId = currentUserAccount
-	balancesResponse.Balance = balancesMap[currentUserAccount]
+	fee, err = sheet.chargeFee(feePolicy, currentUserAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
+	}
 
-	return balancesResponse, nil
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(transferEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
+	return sheet.requestResult(requestId, currentUserAccount, fee)
 }
 
-func (t *CoinChain) BatchTransfer(ctx contractapi.TransactionContextInterface, transferRequestsJson string) (*UserBalance, error) {
+func (t *CoinChain) BatchTransfer(ctx contractapi.TransactionContextInterface, currency string, transferRequestsJson string, requestId string) (*UserBalance, error) {
 	fmt.Println("transfer requests json: " + transferRequestsJson)
 
+	request, err := findRequest(ctx, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	if request != nil {
+		return request.Result, nil
+	}
+
 	var transferRequests []TransferRequest
-	err := json.Unmarshal([]byte(transferRequestsJson), &transferRequests)
+	err = json.Unmarshal([]byte(transferRequestsJson), &transferRequests)
 
 	if err != nil {
-		return nil, err
+		return nil, newError(invalidArgumentCode, "incorrect transfer requests: %s", err.Error())
 	}
 
 	fmt.Println(transferRequests)
 
-	var total = 0
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, tr := range transferRequests {
-		total += tr.Amount
+	amounts, total, err := validateTransferRequests(transferRequests, sheet.decimals)
+	if err != nil {
+		return nil, err
 	}
 
 	currentUserId, err := getCurrentUserId(ctx)
@@ -177,136 +357,181 @@ func (t *CoinChain) BatchTransfer(ctx contractapi.TransactionContextInterface, t
 
 	fmt.Println("currentUserAccount " + currentUserAccount)
 
-	balancesMap := t.getMap(ctx, balancesKey)
-
-	currentUserBalance := balancesMap[currentUserAccount]
+	currentUserBalance, err := sheet.balanceOf(currentUserAccount)
+	if err != nil {
+		return nil, err
+	}
 
 	fmt.Println("currentUserBalance ", currentUserBalance)
 
-	if total > currentUserBalance {
-		return nil, errors.New("not enough money")
+	if total.Cmp(currentUserBalance) > 0 {
+		return nil, newError(insufficientFundsCode, "not enough money")
+	}
+
+	feePolicy, err := getFeePolicy(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, tr := range transferRequests {
+	// The fees count toward the limits of the sender
+	spent := make([]*big.Int, len(amounts))
+	for i, tr := range transferRequests {
 		receiverAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{tr.UserId})
 		if err != nil {
 			return nil, err
 		}
-		balancesMap[currentUserAccount] -= tr.Amount
-		balancesMap[receiverAccount] += tr.Amount
+
+		fee, err := sheet.feeOf(feePolicy, currentUserAccount, receiverAccount, amounts[i])
+		if err != nil {
+			return nil, err
+		}
+		spent[i] = new(big.Int).Add(amounts[i], fee)
 	}
 
-	err = t.saveMap(ctx, balancesKey, balancesMap)
+	err = checkLimits(ctx, sheet, currentUserAccount, spent, true)
 	if err != nil {
 		return nil, err
 	}
 
-	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
-	balancesResponse := new(UserBalance)
-	balancesResponse.UserId = currentUserAccount
-	balancesResponse.Balance = balancesMap[currentUserAccount]
+	fees := new(big.Int)
 
-	return balancesResponse, nil
+	for i, tr := range transferRequests {
+		if tr.UserId == currentUserId {
+			return nil, newError(invalidReceiverCode, "can not transfer coins to yourself")
+		}
+		err = checkReceiver(ctx, userAccountType, tr.UserId)
+		if err != nil {
+			return nil, err
+		}
+		receiverAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{tr.UserId})
+		if err != nil {
+			return nil, err
+		}
+		err = sheet.transferWithMemo(currentUserAccount, receiverAccount, amounts[i], tr.Memo, tr.Reference)
+		if err != nil {
+			return nil, err
+		}
+		fee, err := sheet.chargeFee(feePolicy, currentUserAccount, receiverAccount, amounts[i])
+		if err != nil {
+			return nil, err
+		}
+		fees.Add(fees, fee)
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(transferEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
+	return sheet.requestResult(requestId, currentUserAccount, fees)
 }
 
-func (t *CoinChain) Refund(ctx contractapi.TransactionContextInterface, projectId string, receiver string, amount int) (*UserBalance, error) {
+func (t *CoinChain) Refund(ctx contractapi.TransactionContextInterface, currency string, projectId string, receiver string, amount string, requestId string) (*UserBalance, error) {
 
 	fmt.Println("receiver " + receiver)
-	fmt.Println("amount " + string(amount))
+	fmt.Println("amount " + amount)
 
-	if amount == 0 {
-		return nil, errors.New("incorrect amount")
+	request, err := findRequest(ctx, requestId)
+	if err != nil {
+		return nil, err
 	}
 
-	currentUserId, err := getCurrentUserId(ctx)
+	if request != nil {
+		return request.Result, nil
+	}
+
+	err = validateReceiver(userAccountType, receiver)
 	if err != nil {
 		return nil, err
 	}
 
-	minterBytes, err := ctx.GetStub().GetState(minterKey)
+	sheet, err := newBalanceSheet(ctx, currency)
 	if err != nil {
 		return nil, err
 	}
 
-	minterString := string(minterBytes)
-	fmt.Println("minter " + minterString)
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
 
-	if currentUserId != minterString {
-		return nil, errors.New("no permissions")
+	_, err = checkRole(ctx, refundOperatorRole)
+	if err != nil {
+		return nil, err
 	}
 
-	projectAccount, err := ctx.GetStub().CreateCompositeKey("project_", []string{projectId})
+	projectAccount, err := ctx.GetStub().CreateCompositeKey(projectAccountType, []string{projectId})
 	if err != nil {
 		return nil, err
 	}
 
 	fmt.Println("projectAccount " + projectAccount)
 
-	receiverAccount, err := ctx.GetStub().CreateCompositeKey("user_", []string{receiver})
+	err = checkReceiver(ctx, userAccountType, receiver)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("receiverAccount " + receiverAccount)
+	receiverAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{receiver})
+	if err != nil {
+		return nil, err
+	}
 
-	balancesMap := t.getMap(ctx, balancesKey)
+	fmt.Println("receiverAccount " + receiverAccount)
 
-	if balancesMap[projectAccount] < amount {
-		return nil, errors.New("not enough coins")
+	err = sheet.transfer(projectAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
 	}
 
-	balancesMap[projectAccount] -= amount
-	balancesMap[receiverAccount] += amount
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
 
-	err = t.saveMap(ctx, balancesKey, balancesMap)
+	err = sheet.emit(refundEventName)
 	if err != nil {
 		return nil, err
 	}
 
 	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
-	balancesResponse := new(UserBalance)
-	balancesResponse.UserId = projectAccount
-	balancesResponse.Balance = balancesMap[projectAccount]
-
-	return balancesResponse, nil
+	return sheet.requestResult(requestId, projectAccount, nil)
 }
 
-func (t *CoinChain) BatchRefund(ctx contractapi.TransactionContextInterface, projectId string, transferRequestsJson string) (*UserBalance, error) {
+func (t *CoinChain) BatchRefund(ctx contractapi.TransactionContextInterface, currency string, projectId string, transferRequestsJson string) (*UserBalance, error) {
 	fmt.Println("refund requests json: " + transferRequestsJson)
 
 	var transferRequests []TransferRequest
 	err := json.Unmarshal([]byte(transferRequestsJson), &transferRequests)
 
 	if err != nil {
-		return nil, err
+		return nil, newError(invalidArgumentCode, "incorrect transfer requests: %s", err.Error())
 	}
 
 	fmt.Println(transferRequests)
 
-	var total = 0
-
-	for _, tr := range transferRequests {
-		total += tr.Amount
-	}
-
-	currentUserId, err := getCurrentUserId(ctx)
+	sheet, err := newBalanceSheet(ctx, currency)
 	if err != nil {
 		return nil, err
 	}
 
-	minterBytes, err := ctx.GetStub().GetState(minterKey)
+	amounts, total, err := validateTransferRequests(transferRequests, sheet.decimals)
 	if err != nil {
 		return nil, err
 	}
 
-	minterString := string(minterBytes)
-	fmt.Println("minter " + minterString)
-
-	if currentUserId != minterString {
-		return nil, errors.New("no permissions")
+	_, err = checkRole(ctx, refundOperatorRole)
+	if err != nil {
+		return nil, err
 	}
 
-	projectAccount, err := ctx.GetStub().CreateCompositeKey("project_", []string{projectId})
+	projectAccount, err := ctx.GetStub().CreateCompositeKey(projectAccountType, []string{projectId})
 
 	if err != nil {
 		return nil, err
@@ -314,61 +539,73 @@ func (t *CoinChain) BatchRefund(ctx contractapi.TransactionContextInterface, pro
 
 	fmt.Println("projectAccount " + projectAccount)
 
-	balancesMap := t.getMap(ctx, balancesKey)
-
-	currentProjectBalance := balancesMap[projectAccount]
+	currentProjectBalance, err := sheet.balanceOf(projectAccount)
+	if err != nil {
+		return nil, err
+	}
 
 	fmt.Println("currentProjectBalance ", currentProjectBalance)
 
-	if total != currentProjectBalance {
-		return nil, errors.New("all money must be refunded")
+	if total.Cmp(currentProjectBalance) != 0 {
+		return nil, newError(invalidAmountCode, "all money must be refunded")
 	}
 
-	for _, tr := range transferRequests {
+	for i, tr := range transferRequests {
+		err = checkReceiver(ctx, userAccountType, tr.UserId)
+		if err != nil {
+			return nil, err
+		}
 		receiverAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{tr.UserId})
 		if err != nil {
 			return nil, err
 		}
-		balancesMap[projectAccount] -= tr.Amount
-		balancesMap[receiverAccount] += tr.Amount
+		err = sheet.transferWithMemo(projectAccount, receiverAccount, amounts[i], tr.Memo, tr.Reference)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = t.saveMap(ctx, balancesKey, balancesMap)
+	err = sheet.save()
 	if err != nil {
 		return nil, err
 	}
 
-	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
-	balancesResponse := new(UserBalance)
-	balancesResponse.UserId = projectAccount
-	balancesResponse.Balance = balancesMap[projectAccount]
+	err = sheet.emit(refundEventName)
+	if err != nil {
+		return nil, err
+	}
 
-	return balancesResponse, nil
+	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
+	return sheet.userBalance(projectAccount)
 }
 
-func (t *CoinChain) Mint(ctx contractapi.TransactionContextInterface, amount int) (*UserBalance, error) {
+func (t *CoinChain) Mint(ctx contractapi.TransactionContextInterface, currency string, amount string) (*UserBalance, error) {
 
-	fmt.Println("mint amount: " + string(amount))
+	fmt.Println("mint " + currency + " amount: " + amount)
 
-	minterBytes, err := ctx.GetStub().GetState(minterKey)
+	sheet, err := newBalanceSheet(ctx, currency)
 	if err != nil {
 		return nil, err
 	}
 
-	minterString := string(minterBytes)
-	fmt.Println("minter " + minterString)
+	currentUserId, err := checkMinter(ctx, sheet.currency)
+	if err != nil {
+		return nil, err
+	}
 
-	currentUserId, err := getCurrentUserId(ctx)
+	value, err := parseAmount(amount, sheet.decimals)
 	if err != nil {
 		return nil, err
 	}
 
-	if currentUserId != minterString {
-		return nil, errors.New("no permissions")
+	policy, err := getMintPolicy(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if amount == 0 {
-		return nil, errors.New("incorrect amount")
+	// Multi-signature minting applies to every currency
+	if policy.Threshold > 0 {
+		return nil, newError(invalidStateCode, "minting requires approvals, use ProposeMint")
 	}
 
 	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
@@ -378,24 +615,141 @@ func (t *CoinChain) Mint(ctx contractapi.TransactionContextInterface, amount int
 
 	fmt.Println("currentUserAccount " + currentUserAccount)
 
-	balancesMap := t.getMap(ctx, balancesKey)
+	err = sheet.mint(currentUserAccount, value)
+	if err != nil {
+		return nil, err
+	}
 
-	balancesMap[currentUserAccount] += amount
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
 
-	err = t.saveMap(ctx, balancesKey, balancesMap)
+	err = sheet.emit(mintEventName)
 	if err != nil {
 		return nil, err
 	}
 
 	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
-	balancesResponse := new(UserBalance)
-	balancesResponse.UserId = currentUserAccount
-	balancesResponse.Balance = balancesMap[currentUserAccount]
+	return sheet.userBalance(currentUserAccount)
+}
 
-	return balancesResponse, nil
+// Burn destroys coins of the given account. Users can burn their own coins,
+// the minter of the currency can burn coins of any account.
+func (t *CoinChain) Burn(ctx contractapi.TransactionContextInterface, currency string, accountType string, accountId string, amount string, reason string) (*UserBalance, error) {
+
+	fmt.Println("burn amount: " + amount)
+	fmt.Println("reason " + reason)
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reason) == 0 {
+		return nil, newError(invalidArgumentCode, "reason is required")
+	}
+
+	if isInternalAccountType(accountType) {
+		return nil, newError(invalidArgumentCode, "coins of %s accounts can not be burned", accountType)
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountType != userAccountType || accountId != currentUserId {
+		_, err = checkMinter(ctx, sheet.currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	account, err := ctx.GetStub().CreateCompositeKey(accountType, []string{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("account " + account)
+
+	err = sheet.burn(account, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(burnEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	burnKey, err := ctx.GetStub().CreateCompositeKey(burnPrefix, []string{ctx.GetStub().GetTxID()})
+	if err != nil {
+		return nil, err
+	}
+
+	burnBytes, err := json.Marshal(BurnRecord{Currency: sheet.currency.Symbol, Account: account, Amount: formatAmount(value, sheet.decimals), Reason: reason, BurntBy: currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(burnKey, burnBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
+	return sheet.userBalance(account)
+}
+
+func (t *CoinChain) TotalSupply(ctx contractapi.TransactionContextInterface, currency string) (string, error) {
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return "", err
+	}
+
+	totalSupply, err := getTotalSupply(ctx, currencyInfo.Symbol)
+	if err != nil {
+		return "", err
+	}
+
+	return formatAmount(totalSupply, currencyInfo.Decimals), nil
 }
 
-func (t *CoinChain) BalanceOf(ctx contractapi.TransactionContextInterface, accountType string, accountId string) (*UserBalance, error) {
+func (t *CoinChain) TokenInfo(ctx contractapi.TransactionContextInterface, currency string) (*TokenInfo, error) {
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	totalSupply, err := getTotalSupply(ctx, currencyInfo.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenInfo := new(TokenInfo)
+	tokenInfo.Currency = currencyInfo.Symbol
+	tokenInfo.Name = currencyInfo.Name
+	tokenInfo.Minter = currencyInfo.Minter
+	tokenInfo.TotalSupply = formatAmount(totalSupply, currencyInfo.Decimals)
+	tokenInfo.Decimals = currencyInfo.Decimals
+
+	return tokenInfo, nil
+}
+
+func (t *CoinChain) BalanceOf(ctx contractapi.TransactionContextInterface, currency string, accountType string, accountId string) (*UserBalance, error) {
 
 	fmt.Println("accountType " + accountType)
 	fmt.Println("accountId " + accountId)
@@ -407,22 +761,24 @@ func (t *CoinChain) BalanceOf(ctx contractapi.TransactionContextInterface, accou
 
 	fmt.Println("account " + account)
 
-	balancesMap := t.getMap(ctx, balancesKey)
-
-	balancesResponse := new(UserBalance)
-	balancesResponse.UserId = account
-	balancesResponse.Balance = balancesMap[account]
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
 
-	return balancesResponse, nil
+	return sheet.userBalanceWithHolds(account)
 }
 
-func (t *CoinChain) BatchBalanceOf(ctx contractapi.TransactionContextInterface, emails []string) ([]*UserBalance, error) {
+func (t *CoinChain) BatchBalanceOf(ctx contractapi.TransactionContextInterface, currency string, emails []string) ([]*UserBalance, error) {
 
 	fmt.Println("userId " + strings.Join(emails, ", "))
 
 	var balancesResponse []*UserBalance
 
-	balancesMap := t.getMap(ctx, balancesKey)
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, email := range emails {
 		account, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{email})
@@ -432,126 +788,282 @@ func (t *CoinChain) BatchBalanceOf(ctx contractapi.TransactionContextInterface,
 
 		fmt.Println("account " + account)
 
-		balance := new(UserBalance)
+		balance, err := sheet.userBalanceWithHolds(account)
+		if err != nil {
+			return nil, err
+		}
 		balance.UserId = email
-		balance.Balance = balancesMap[account]
 		balancesResponse = append(balancesResponse, balance)
 	}
 
 	return balancesResponse, nil
 }
 
-func (t *CoinChain) AllBalances(ctx contractapi.TransactionContextInterface) ([]*UserBalance, error) {
+// AllBalances returns every balance at once. Account ids of user_ accounts
+// are returned as is, other accounts are prefixed with the account type.
+// Use ListBalances for large ledgers.
+func (t *CoinChain) AllBalances(ctx contractapi.TransactionContextInterface, currency string) ([]*UserBalance, error) {
 
-	balancesMap := t.getMap(ctx, balancesKey)
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
 
-	keys := reflect.ValueOf(balancesMap).MapKeys()
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(balancePrefix, []string{currencyInfo.Symbol})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
 
 	var balancesResponse []*UserBalance
 
-	for i := 0; i < len(keys); i++ {
-		account := keys[i].String()
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
 
-		fmt.Println("account " + account)
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Println("account ", attributes)
+
+		value, err := readAmount(entry.Value)
+		if err != nil {
+			return nil, err
+		}
 
 		balance := new(UserBalance)
-		balance.UserId = t.trimCompositeKey(account)
-		balance.Balance = balancesMap[account]
+		balance.UserId = strings.TrimPrefix(strings.Join(attributes[1:], ""), userAccountType)
+		balance.Balance = formatAmount(value, currencyInfo.Decimals)
 		balancesResponse = append(balancesResponse, balance)
 	}
 
 	return balancesResponse, nil
 }
 
-func getCurrentUserId(ctx contractapi.TransactionContextInterface) (string, error) {
+// ListBalances returns one page of balances of the given account type (all
+// types if empty) which are not less than minBalance. Pass the bookmark of the
+// previous page to get the next one. Only auditors can list balances.
+func (t *CoinChain) ListBalances(ctx contractapi.TransactionContextInterface, currency string, accountType string, pageSize int32, bookmark string, minBalance string) (*BalancesPage, error) {
+
+	fmt.Println("accountType " + accountType)
 
-	var userId string
+	if pageSize <= 0 {
+		return nil, newError(invalidArgumentCode, "incorrect page size %d", pageSize)
+	}
 
-	creatorBytes, err := ctx.GetStub().GetCreator()
+	_, err := checkRole(ctx, auditorRole)
 	if err != nil {
-		return userId, err
+		return nil, err
 	}
 
-	creatorString := fmt.Sprintf("%s", creatorBytes)
-
-	index := strings.Index(creatorString, "-----BEGIN CERTIFICATE-----")
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
 
-	if index == -1 {
-		index = strings.Index(creatorString, "-----BEGIN -----")
+	minValue := new(big.Int)
+	if len(minBalance) != 0 {
+		minValue, err = parseNonNegativeAmount(minBalance, currencyInfo.Decimals)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	certificate := creatorString[index:]
-	block, _ := pem.Decode([]byte(certificate))
+	attributes := []string{currencyInfo.Symbol}
+	if len(accountType) != 0 {
+		attributes = append(attributes, accountType)
+	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(balancePrefix, attributes, pageSize, bookmark)
 	if err != nil {
-		return userId, err
+		return nil, err
 	}
+	defer iterator.Close()
+
+	page := new(BalancesPage)
+	page.Balances = []*AccountBalance{}
 
-	userId = cert.Subject.CommonName
-	return userId, err
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := readAmount(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if balance.Cmp(minValue) < 0 {
+			continue
+		}
+
+		_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Balances = append(page.Balances, &AccountBalance{
+			AccountType: keyAttributes[1],
+			AccountId:   keyAttributes[2],
+			Balance:     formatAmount(balance, currencyInfo.Decimals),
+		})
+	}
+
+	page.Bookmark = metadata.Bookmark
+
+	return page, nil
 }
 
-func (t *CoinChain) getMap(ctx contractapi.TransactionContextInterface, mapName string) map[string]int {
+// MigrateBalances moves balances from the legacy "balances" map into
+// per-account keys of the default currency and deletes the map. It is a no-op once the map is gone.
+func (t *CoinChain) MigrateBalances(ctx contractapi.TransactionContextInterface) (int, error) {
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return 0, err
+	}
 
-	fmt.Println("------ getMap called")
+	balancesMap, err := t.getMap(ctx, balancesKey)
+	if err != nil {
+		return 0, err
+	}
 
-	mapBytes, err := ctx.GetStub().GetState(mapName)
+	accounts := make([]string, 0, len(balancesMap))
+	for account := range balancesMap {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	sheet, err := newBalanceSheet(ctx, "")
 	if err != nil {
-		return nil
+		return 0, err
 	}
 
-	var mapObject map[string]int
-	err = json.Unmarshal(mapBytes, &mapObject)
+	for _, account := range accounts {
+		fmt.Println("migrate account ", account, balancesMap[account])
+
+		// Add instead of overwrite: the account may have received coins
+		// after the upgrade but before the migration. Legacy balances were
+		// never counted in the total supply, so count them now.
+		err = sheet.mint(account, big.NewInt(int64(balancesMap[account])))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = sheet.save()
 	if err != nil {
-		return nil
+		return 0, err
 	}
 
-	return mapObject
+	err = ctx.GetStub().DelState(balancesKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(accounts), nil
 }
 
-func (t *CoinChain) saveMap(ctx contractapi.TransactionContextInterface, mapName string, mapObject map[string]int) error {
-	fmt.Println("------ saveBalancesMap called")
+// getCurrentUserId resolves the account id of the caller from its client
+// identity: the coins.userId enrollment attribute if present, the certificate
+// common name otherwise. Users of other organizations than the one the ledger
+// was initialized by are namespaced by their MSP ID (<MSP ID>::<user id>), so
+// users with the same name in different organizations never share a balance.
+func getCurrentUserId(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	clientIdentity := ctx.GetClientIdentity()
+
+	mspId, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return "", newError(invalidIdentityCode, "failed to get caller MSP ID: %s", err.Error())
+	}
 
-	balancesMapBytes, err := json.Marshal(mapObject)
+	userId, found, err := clientIdentity.GetAttributeValue(userIdAttribute)
 	if err != nil {
-		return err
+		return "", newError(invalidIdentityCode, "failed to get caller attributes: %s", err.Error())
+	}
+
+	if !found {
+		cert, err := clientIdentity.GetX509Certificate()
+		if err != nil {
+			return "", newError(invalidIdentityCode, "failed to get caller certificate: %s", err.Error())
+		}
+
+		if cert == nil {
+			return "", newError(invalidIdentityCode, "caller is not identified by an X509 certificate")
+		}
+
+		userId = cert.Subject.CommonName
 	}
 
-	err = ctx.GetStub().PutState(mapName, balancesMapBytes)
+	if len(userId) == 0 || strings.Contains(userId, mspSeparator) {
+		return "", newError(invalidIdentityCode, "malformed caller identity %q", userId)
+	}
+
+	homeMspBytes, err := ctx.GetStub().GetState(homeMspKey)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	if mspId != string(homeMspBytes) {
+		userId = mspId + mspSeparator + userId
 	}
 
-	return nil
+	return userId, nil
 }
 
-func (t *CoinChain) GetTransactionBalancesMap(ctx contractapi.TransactionContextInterface) map[string]int {
+// getTxTime returns the transaction timestamp. Use it instead of the wall
+// clock, which differs between endorsing peers.
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
 
-	txId := ctx.GetStub().GetTxID()
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
 
-	fmt.Println("lastTxId " + lastTxId)
-	fmt.Println("txId " + txId)
+// isExpired checks an RFC3339 expiry time against the transaction timestamp.
+func isExpired(ctx contractapi.TransactionContextInterface, expires string) (bool, error) {
 
-	if txId == lastTxId {
-		return txBalancesMap
-	} else {
-		txBalancesMap = t.getMap(ctx, balancesKey)
-		lastTxId = txId
+	expiryTime, err := time.Parse(time.RFC3339Nano, expires)
+	if err != nil {
+		return false, err
 	}
-	return txBalancesMap
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return !txTime.Before(expiryTime), nil
 }
 
-func (t *CoinChain) trimCompositeKey(inputStr string) string {
-	reg, err := regexp.Compile("[^a-zA-Z0-9@.!#$%&'*+-/=?^_`{|}~]+")
+// getMap returns a legacy map of balances, empty if it is not stored.
+func (t *CoinChain) getMap(ctx contractapi.TransactionContextInterface, mapName string) (map[string]int, error) {
+
+	mapBytes, err := ctx.GetStub().GetState(mapName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	result := reg.ReplaceAllString(inputStr, "")
-	result = strings.TrimPrefix(result, userAccountType)
+	mapObject := make(map[string]int)
+	if len(mapBytes) == 0 {
+		return mapObject, nil
+	}
+
+	err = json.Unmarshal(mapBytes, &mapObject)
+	if err != nil {
+		return nil, err
+	}
 
-	return result
+	return mapObject, nil
 }
 
 func main() {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCoinChain(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "init again by user", caller: test.alice, function: "InitLedger", args: []string{"alice", "SJ", "2"}, err: noPermissionsCode},
+		{name: "init again by partner organization", caller: test.partner, function: "InitLedger", args: []string{"alice", "SJ", "2"}, err: noPermissionsCode},
+		{name: "init with bad decimals", caller: test.minter, function: "InitLedger", args: []string{"sj_coin", "SJ", "19"}, err: invalidArgumentCode},
+		{name: "init with another symbol", caller: test.minter, function: "InitLedger", args: []string{"sj_coin", "XX"}, err: invalidStateCode},
+		{name: "init without symbol", caller: test.minter, function: "InitLedger", args: []string{"sj_coin"}, err: invalidArgumentCode},
+
+		{name: "mint by user", caller: test.alice, function: "Mint", args: []string{"", "10"}, err: noPermissionsCode},
+		{name: "mint zero", caller: test.minter, function: "Mint", args: []string{"", "0"}, err: invalidAmountCode},
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "1000"}, want: `{"balance":"1000"}`, event: mintEventName},
+		{name: "mint unknown currency", caller: test.minter, function: "Mint", args: []string{"XX", "10"}, err: notFoundCode},
+
+		{name: "init with other decimals", caller: test.minter, function: "InitLedger", args: []string{"sj_coin", "SJ", "3"}, err: invalidStateCode},
+		{name: "init with another minter", caller: test.admin, function: "InitLedger", args: []string{"alice", "SJ", "2"}, err: invalidStateCode},
+
+		{name: "transfer", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "salary", "inv-1"}, want: `{"userId":` + jsonString(account(userAccountType, "sj_coin")) + `,"balance":"900"}`, event: transferEventName,
+			check: func(t *testing.T, payload []byte) {
+				event := new(CoinEvent)
+				test.eventOf(t, event)
+				assertContains(t, `[{"from":"sj_coin","fromType":"user_","to":"alice","toType":"user_","amount":"100","memo":"salary","reference":"inv-1"}]`, mustMarshal(t, event.Transfers))
+			}},
+		{name: "transfer zero", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "0", "", "", ""}, err: invalidAmountCode},
+		{name: "transfer with long memo", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", strings.Repeat("m", maxMemoLength+1), ""}, err: invalidArgumentCode},
+		{name: "transfer negative", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "-5", "", "", ""}, err: invalidAmountCode},
+		{name: "transfer to yourself", caller: test.alice, function: "Transfer", args: []string{"", "user_", "alice", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "transfer to unknown account type", caller: test.alice, function: "Transfer", args: []string{"", "bank_", "b1", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "transfer without receiver", caller: test.alice, function: "Transfer", args: []string{"", "user_", "", "1", "", "", ""}, err: invalidReceiverCode},
+		{name: "transfer too much", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1000", "", "", ""}, err: insufficientFundsCode},
+		{name: "transfer too many decimals", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "0.001", "", "", ""}, err: invalidAmountCode},
+		{name: "transfer with decimals", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "11.5", "", "", ""}, want: `{"balance":"88.5"}`},
+		{name: "transfer to bob", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "0.5", "", "", ""}, want: `{"balance":"88"}`},
+
+		{name: "batch transfer", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"1","memo":"tip"},{"userId":"carol","amount":3}]`, ""}, want: `{"balance":"84"}`, event: transferEventName,
+			check: func(t *testing.T, payload []byte) {
+				event := new(CoinEvent)
+				test.eventOf(t, event)
+				assertContains(t, `[{"to":"bob","amount":"1","memo":"tip"},{"to":"carol","amount":"3"}]`, mustMarshal(t, event.Transfers))
+			}},
+		{name: "batch transfer duplicate receiver", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"1"},{"userId":"bob","amount":"1"}]`, ""}, err: duplicateReceiverCode},
+		{name: "batch transfer to yourself", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"alice","amount":"1"}]`, ""}, err: invalidReceiverCode},
+		{name: "batch transfer negative", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"5"},{"userId":"carol","amount":"-5"}]`, ""}, err: invalidAmountCode},
+		{name: "batch transfer too much", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"80"},{"userId":"carol","amount":"5"}]`, ""}, err: insufficientFundsCode},
+		{name: "batch transfer malformed", caller: test.alice, function: "BatchTransfer", args: []string{"", `{"userId":"bob"}`, ""}, err: invalidArgumentCode},
+		{name: "batch transfer empty", caller: test.alice, function: "BatchTransfer", args: []string{"", `[]`, ""}, err: invalidArgumentCode},
+
+		{name: "donate to project", caller: test.alice, function: "Transfer", args: []string{"", "project_", "p1", "20", "", "", ""}, want: `{"balance":"64"}`},
+		{name: "refund by user", caller: test.alice, function: "Refund", args: []string{"", "p1", "bob", "5", ""}, err: noPermissionsCode},
+		{name: "refund more than collected", caller: test.minter, function: "Refund", args: []string{"", "p1", "bob", "25", ""}, err: insufficientFundsCode},
+		{name: "refund", caller: test.minter, function: "Refund", args: []string{"", "p1", "bob", "5", ""}, want: `{"userId":` + jsonString(account(projectAccountType, "p1")) + `,"balance":"15"}`, event: refundEventName},
+		{name: "batch refund of a part", caller: test.minter, function: "BatchRefund", args: []string{"", "p1", `[{"userId":"alice","amount":"10"}]`}, err: invalidAmountCode},
+		{name: "batch refund", caller: test.minter, function: "BatchRefund", args: []string{"", "p1", `[{"userId":"alice","amount":"10"},{"userId":"carol","amount":"5"}]`}, want: `{"balance":"0"}`, event: refundEventName},
+
+		{name: "burn without reason", caller: test.alice, function: "Burn", args: []string{"", "user_", "alice", "4", ""}, err: invalidArgumentCode},
+		{name: "burn own coins", caller: test.alice, function: "Burn", args: []string{"", "user_", "alice", "4", "lost key"}, want: `{"balance":"70"}`, event: burnEventName,
+			check: func(t *testing.T, payload []byte) {
+				event := new(CoinEvent)
+				test.eventOf(t, event)
+				assertContains(t, `[{"from":"alice","fromType":"user_","to":"","toType":"","amount":"4"}]`, mustMarshal(t, event.Transfers))
+			}},
+		{name: "burn coins of others", caller: test.alice, function: "Burn", args: []string{"", "user_", "bob", "1", "fine"}, err: noPermissionsCode},
+		{name: "burn more than balance", caller: test.minter, function: "Burn", args: []string{"", "user_", "bob", "100", "fine"}, err: insufficientFundsCode},
+		{name: "burn by minter", caller: test.minter, function: "Burn", args: []string{"", "user_", "bob", "1", "fine"}, want: `{"balance":"17"}`},
+		{name: "burn coins of escrow account", caller: test.minter, function: "Burn", args: []string{"", "escrow_", "h1", "1", "fine"}, err: invalidArgumentCode},
+
+		{name: "total supply", caller: test.alice, function: "TotalSupply", args: []string{""}, want: "995"},
+		{name: "token info", caller: test.alice, function: "TokenInfo", args: []string{"SJ"}, want: `{"currency":"SJ","name":"SJ","minter":"sj_coin","totalSupply":"995","decimals":2}`},
+		{name: "balance", caller: test.bob, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"userId":` + jsonString(account(userAccountType, "alice")) + `,"balance":"70"}`},
+		{name: "batch balances", caller: test.bob, function: "BatchBalanceOf", args: []string{"", `["alice","bob","nobody"]`},
+			want: `[{"userId":"alice","balance":"70"},{"userId":"bob","balance":"17"},{"userId":"nobody","balance":"0"}]`},
+		{name: "all balances", caller: test.bob, function: "AllBalances", args: []string{""},
+			want: `[{"userId":"project_p1","balance":"0"},{"userId":"alice","balance":"70"},{"userId":"bob","balance":"17"},{"userId":"carol","balance":"8"},{"userId":"sj_coin","balance":"900"}]`},
+
+		{name: "list balances by user", caller: test.alice, function: "ListBalances", args: []string{"", "", "10", "", "0"}, err: noPermissionsCode},
+		{name: "list balances with bad page size", caller: test.auditor, function: "ListBalances", args: []string{"", "", "0", "", "0"}, err: invalidArgumentCode},
+		{name: "list balances page", caller: test.auditor, function: "ListBalances", args: []string{"", "user_", "2", "", "0"},
+			want: `{"balances":[{"accountType":"user_","accountId":"alice","balance":"70"},{"accountType":"user_","accountId":"bob","balance":"17"}]}`,
+			check: func(t *testing.T, payload []byte) {
+				page := new(BalancesPage)
+				mustUnmarshal(t, payload, page)
+
+				response := test.network.invoke(test.auditor, coinsChaincode, "ListBalances", "", "user_", "2", page.Bookmark, "0")
+				assertContains(t, `{"balances":[{"accountId":"carol","balance":"8"},{"accountId":"sj_coin","balance":"900"}],"bookmark":""}`, response.Payload)
+			}},
+		{name: "list balances above minimum", caller: test.auditor, function: "ListBalances", args: []string{"", "", "10", "", "50"},
+			want: `{"balances":[{"accountId":"alice","balance":"70"},{"accountId":"sj_coin","balance":"900"}],"bookmark":""}`},
+	})
+}
+
+func TestPartnerOrganization(t *testing.T) {
+	test := newCoinsTest(t)
+	partnerId := partnerMsp + mspSeparator + "alice"
+	partnerAdmin := newMockIdentity(t, partnerMsp, "admin", map[string]string{roleAttribute: adminRole})
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "10"}},
+		{name: "transfer to partner user", caller: test.minter, function: "Transfer", args: []string{"", "user_", partnerId, "3", "", "", ""}, want: `{"balance":"7"}`},
+		{name: "home user keeps own balance", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"0"}`},
+		{name: "partner user spends", caller: test.partner, function: "Transfer", args: []string{"", "user_", "alice", "1", "", "", ""}, want: `{"userId":` + jsonString(account(userAccountType, partnerId)) + `,"balance":"2"}`},
+		{name: "partner user can not mint", caller: test.partner, function: "Mint", args: []string{"", "10"}, err: noPermissionsCode},
+		{name: "init again by partner admin", caller: partnerAdmin, function: "InitLedger", args: []string{"sj_coin", "SJ", "2"}},
+		{name: "home organization kept", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, want: `{"userId":` + jsonString(account(userAccountType, "alice")) + `,"balance":"0"}`},
+	})
+}
+
+func TestMigrateBalances(t *testing.T) {
+	test := newCoinsTest(t)
+
+	legacyBalances, err := json.Marshal(map[string]int{account(userAccountType, "dave"): 500, account(projectAccountType, "p1"): 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.network.setState(coinsChaincode, balancesKey, legacyBalances)
+
+	test.run(t, []txCase{
+		{name: "migrate by user", caller: test.alice, function: "MigrateBalances", err: noPermissionsCode},
+		{name: "mint before migration", caller: test.minter, function: "Mint", args: []string{"", "10"}},
+		{name: "receive before migration", caller: test.minter, function: "Transfer", args: []string{"", "user_", "dave", "10", "", "", ""}},
+		{name: "migrate", caller: test.admin, function: "MigrateBalances", want: "2"},
+		{name: "migrated balance", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "dave"}, want: `{"balance":"15"}`},
+		{name: "migrated project balance", caller: test.alice, function: "BalanceOf", args: []string{"", "project_", "p1"}, want: `{"balance":"2.5"}`},
+		{name: "migrated supply", caller: test.alice, function: "TotalSupply", args: []string{""}, want: "17.5"},
+		{name: "migrate again", caller: test.admin, function: "MigrateBalances", want: "0",
+			check: func(t *testing.T, payload []byte) { test.network.setState(coinsChaincode, balancesKey, []byte("{")) }},
+		{name: "migrate malformed map", caller: test.admin, function: "MigrateBalances", err: "unexpected end of JSON input"},
+	})
+}
+
+func mustMarshal(t *testing.T, value interface{}) []byte {
+	t.Helper()
+
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return valueBytes
+}
+
+func mustUnmarshal(t *testing.T, payload []byte, value interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(payload, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/helper"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Currencies are stored under currency~<symbol>. Balances, allowances, the
+// total supply, fees and limits are kept per currency. The currency created
+// by InitLedger is the default one, an empty currency argument means the
+// default currency.
+var currencyPrefix = "currency"
+var supplyPrefix = "supply"
+
+type Currency struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Minter   string `json:"minter"`
+	Decimals int    `json:"decimals"`
+}
+
+// CreateCurrency registers a new currency. Only its minter can mint it.
+func (t *CoinChain) CreateCurrency(ctx contractapi.TransactionContextInterface, symbol string, name string, minter string, decimals int) (*Currency, error) {
+
+	fmt.Println("create currency " + symbol + " (" + name + "), minter " + minter)
+
+	err := validateSymbol(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(name) == 0 || len(minter) == 0 {
+		return nil, newError(invalidArgumentCode, "name and minter are required")
+	}
+
+	if decimals < 0 || decimals > helper.MaxDecimals {
+		return nil, newError(invalidArgumentCode, "incorrect decimals %d", decimals)
+	}
+
+	_, err = checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(currencyPrefix, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	currencyBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(currencyBytes) != 0 {
+		return nil, newError(invalidStateCode, "currency %s already exists", symbol)
+	}
+
+	currency := &Currency{Symbol: symbol, Name: name, Minter: minter, Decimals: decimals}
+
+	err = saveCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return currency, nil
+}
+
+func (t *CoinChain) GetCurrency(ctx contractapi.TransactionContextInterface, symbol string) (*Currency, error) {
+	return getCurrency(ctx, symbol)
+}
+
+// Currencies returns all currencies ordered by symbol.
+func (t *CoinChain) Currencies(ctx contractapi.TransactionContextInterface) ([]*Currency, error) {
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(currencyPrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	currencies := []*Currency{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		currency := new(Currency)
+		err = json.Unmarshal(entry.Value, currency)
+		if err != nil {
+			return nil, err
+		}
+		currencies = append(currencies, currency)
+	}
+
+	return currencies, nil
+}
+
+// checkMinter returns the current user if it may mint the currency: its
+// minter, or a user with the minter role for the default currency.
+func checkMinter(ctx contractapi.TransactionContextInterface, currency *Currency) (string, error) {
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if currentUserId == currency.Minter {
+		return currentUserId, nil
+	}
+
+	defaultSymbol, err := getDefaultCurrency(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if currency.Symbol != defaultSymbol {
+		return "", newError(noPermissionsCode, "no permissions")
+	}
+
+	return checkRole(ctx, minterRole)
+}
+
+// getCurrency returns the currency with the symbol, the default currency if
+// the symbol is empty.
+func getCurrency(ctx contractapi.TransactionContextInterface, symbol string) (*Currency, error) {
+
+	if len(symbol) == 0 {
+		var err error
+		symbol, err = getDefaultCurrency(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(currencyPrefix, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	currencyBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(currencyBytes) == 0 {
+		return nil, newError(notFoundCode, "currency %s not found", symbol)
+	}
+
+	currency := new(Currency)
+	err = json.Unmarshal(currencyBytes, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return currency, nil
+}
+
+func getDefaultCurrency(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	currencyBytes, err := ctx.GetStub().GetState(currencyKey)
+	if err != nil {
+		return "", err
+	}
+
+	if len(currencyBytes) == 0 {
+		return "", newError(invalidStateCode, "ledger is not initialized")
+	}
+
+	return string(currencyBytes), nil
+}
+
+func saveCurrency(ctx contractapi.TransactionContextInterface, currency *Currency) error {
+
+	key, err := ctx.GetStub().CreateCompositeKey(currencyPrefix, []string{currency.Symbol})
+	if err != nil {
+		return err
+	}
+
+	currencyBytes, err := json.Marshal(currency)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, currencyBytes)
+}
+
+// Symbols can not end with "_", so they are never confused with account
+// types.
+func validateSymbol(symbol string) error {
+	if len(symbol) == 0 || strings.HasSuffix(symbol, "_") || strings.Contains(symbol, mspSeparator) {
+		return newError(invalidArgumentCode, "incorrect currency symbol %q", symbol)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestCurrencies(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "create by user", caller: test.alice, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}, err: noPermissionsCode},
+		{name: "create with bad symbol", caller: test.admin, function: "CreateCurrency", args: []string{"user_", "Euro", "carol", "2"}, err: invalidArgumentCode},
+		{name: "create without minter", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "", "2"}, err: invalidArgumentCode},
+		{name: "create with bad decimals", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "-1"}, err: invalidArgumentCode},
+		{name: "create", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}, want: `{"symbol":"EUR","name":"Euro","minter":"carol","decimals":2}`},
+		{name: "create existing", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}, err: invalidStateCode},
+
+		{name: "currency", caller: test.alice, function: "GetCurrency", args: []string{"EUR"}, want: `{"symbol":"EUR","minter":"carol"}`},
+		{name: "default currency", caller: test.alice, function: "GetCurrency", args: []string{""}, want: `{"symbol":"SJ","minter":"sj_coin"}`},
+		{name: "unknown currency", caller: test.alice, function: "GetCurrency", args: []string{"XX"}, err: notFoundCode},
+		{name: "currencies", caller: test.alice, function: "Currencies", want: `[{"symbol":"EUR"},{"symbol":"SJ"}]`},
+
+		{name: "mint by default minter", caller: test.minter, function: "Mint", args: []string{"EUR", "10"}, err: noPermissionsCode},
+		{name: "mint by currency minter", caller: test.carol, function: "Mint", args: []string{"EUR", "10"}, want: `{"balance":"10"}`},
+		{name: "transfer", caller: test.carol, function: "Transfer", args: []string{"EUR", "user_", "alice", "4", "", "", ""}, want: `{"balance":"6"}`},
+		{name: "balance in currency", caller: test.alice, function: "BalanceOf", args: []string{"EUR", "user_", "alice"}, want: `{"balance":"4"}`},
+		{name: "balance in default currency", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"0"}`},
+		{name: "supply in currency", caller: test.alice, function: "TotalSupply", args: []string{"EUR"}, want: "10"},
+		{name: "supply in default currency", caller: test.alice, function: "TotalSupply", args: []string{""}, want: "0"},
+	})
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Held coins are moved from the payer account to the payer's escrow account
+// escrow_<payer id>, so they are not spendable but still counted in the
+// supply. The fee of the capture is held with them. Holds are stored under hold~<hold id> and indexed by payer under
+// payerHold~<payer id>~<hold id>.
+var escrowAccountType = "escrow_"
+var holdPrefix = "hold"
+var payerHoldPrefix = "payerHold"
+
+var holdEventName = "Hold"
+var captureEventName = "Capture"
+var releaseEventName = "Release"
+
+var heldStatus = "held"
+var capturedStatus = "captured"
+var releasedStatus = "released"
+
+// Hold is a reservation of coins. Fee is the fee held for the capture while
+// the hold is active and the fee charged once it is captured.
+type Hold struct {
+	Id        string `json:"id"`
+	Currency  string `json:"currency"`
+	Payer     string `json:"payer"`
+	PayeeType string `json:"payeeType"`
+	Payee     string `json:"payee"`
+	Amount    string `json:"amount"`
+	Fee       string `json:"fee,omitempty" metadata:"fee,optional"`
+	Captured  string `json:"captured"`
+	Expires   string `json:"expires"`
+	Status    string `json:"status"`
+	Created   string `json:"created"`
+}
+
+// Hold reserves amount coins of the current user and the fee of their
+// transfer for the receiver until the expiry time (RFC3339). The receiver settles the hold by Capture, unused
+// coins come back by Release. The transaction ID is the hold ID.
+func (t *CoinChain) Hold(ctx contractapi.TransactionContextInterface, currency string, receiverAccountType string, receiver string, amount string, expiry string) (*Hold, error) {
+
+	fmt.Println("hold for " + receiverAccountType + receiver)
+	fmt.Println("amount " + amount + ", expiry " + expiry)
+
+	err := validateReceiver(receiverAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryTime, err := time.Parse(time.RFC3339Nano, expiry)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "incorrect expiry %s", expiry)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !expiryTime.After(txTime) {
+		return nil, newError(invalidArgumentCode, "expiry must be in the future")
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if receiverAccountType == userAccountType && receiver == currentUserId {
+		return nil, newError(invalidReceiverCode, "can not hold coins for yourself")
+	}
+
+	err = checkReceiver(ctx, receiverAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	escrowAccount, err := ctx.GetStub().CreateCompositeKey(escrowAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	receiverAccount, err := ctx.GetStub().CreateCompositeKey(receiverAccountType, []string{receiver})
+	if err != nil {
+		return nil, err
+	}
+
+	// Coins of expired holds become spendable again before the new hold
+	_, err = releaseExpiredHolds(ctx, sheet, currentUserId)
+	if err != nil {
+		return nil, err
+	}
+
+	feePolicy, err := getFeePolicy(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err := sheet.feeOf(feePolicy, currentUserAccount, receiverAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := new(big.Int).Add(value, fee)
+
+	err = checkLimits(ctx, sheet, currentUserAccount, []*big.Int{reserved}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(currentUserAccount, escrowAccount, reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(holdEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	hold := &Hold{
+		Id:        ctx.GetStub().GetTxID(),
+		Currency:  sheet.currency.Symbol,
+		Payer:     currentUserId,
+		PayeeType: receiverAccountType,
+		Payee:     receiver,
+		Amount:    formatAmount(value, sheet.decimals),
+		Fee:       formatAmount(fee, sheet.decimals),
+		Captured:  "0",
+		Expires:   expiryTime.UTC().Format(time.RFC3339Nano),
+		Status:    heldStatus,
+		Created:   txTime.Format(time.RFC3339Nano),
+	}
+
+	err = saveHold(ctx, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	payerHoldKey, err := ctx.GetStub().CreateCompositeKey(payerHoldPrefix, []string{currentUserId, hold.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(payerHoldKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// Capture pays amount coins of the hold to the receiver and returns the rest
+// to the payer. The fee of the captured amount is paid from the fee held,
+// but never more than it. An empty amount captures the whole hold. Only the
+// receiver (or an admin for accounts which can not sign) can capture before
+// expiry.
+func (t *CoinChain) Capture(ctx contractapi.TransactionContextInterface, holdId string, amount string) (*Hold, error) {
+
+	fmt.Println("capture hold " + holdId + ", amount " + amount)
+
+	hold, err := getHold(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPayee(ctx, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.Status != heldStatus {
+		return nil, newError(invalidStateCode, "hold is already %s", hold.Status)
+	}
+
+	expired, err := isExpired(ctx, hold.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, newError(invalidStateCode, "hold is expired")
+	}
+
+	sheet, err := newBalanceSheet(ctx, hold.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	held, heldFee, err := heldAmounts(hold, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	captured := held
+	if len(amount) != 0 {
+		captured, err = parseAmount(amount, sheet.decimals)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if captured.Cmp(held) > 0 {
+		return nil, newError(invalidAmountCode, "captured amount exceeds the hold")
+	}
+
+	escrowAccount, err := ctx.GetStub().CreateCompositeKey(escrowAccountType, []string{hold.Payer})
+	if err != nil {
+		return nil, err
+	}
+
+	payeeAccount, err := ctx.GetStub().CreateCompositeKey(hold.PayeeType, []string{hold.Payee})
+	if err != nil {
+		return nil, err
+	}
+
+	payerAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{hold.Payer})
+	if err != nil {
+		return nil, err
+	}
+
+	feePolicy, err := getFeePolicy(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fee is calculated as if the payer transferred the captured amount
+	fee, err := sheet.feeOf(feePolicy, payerAccount, payeeAccount, captured)
+	if err != nil {
+		return nil, err
+	}
+
+	if fee.Cmp(heldFee) > 0 {
+		fee = heldFee
+	}
+
+	err = sheet.transfer(escrowAccount, payeeAccount, captured)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.payFee(feePolicy, escrowAccount, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	remainder := new(big.Int).Sub(held, captured)
+	remainder.Add(remainder, heldFee).Sub(remainder, fee)
+	if remainder.Sign() > 0 {
+		err = sheet.transfer(escrowAccount, payerAccount, remainder)
+		if err != nil {
+			return nil, err
+		}
+
+		err = returnOutflow(ctx, hold, remainder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(captureEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Captured = formatAmount(captured, sheet.decimals)
+	hold.Fee = formatAmount(fee, sheet.decimals)
+	hold.Status = capturedStatus
+
+	err = closeHold(ctx, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// Release returns held coins to the payer. The receiver or an admin can
+// release a hold at any time, the payer only after it expired.
+func (t *CoinChain) Release(ctx contractapi.TransactionContextInterface, holdId string) (*Hold, error) {
+
+	fmt.Println("release hold " + holdId)
+
+	hold, err := getHold(ctx, holdId)
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.Status != heldStatus {
+		return nil, newError(invalidStateCode, "hold is already %s", hold.Status)
+	}
+
+	expired, err := isExpired(ctx, hold.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	if !expired {
+		err = checkPayee(ctx, hold)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := newBalanceSheet(ctx, hold.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = releaseHold(ctx, sheet, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(releaseEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// ReleaseExpiredHolds returns coins of all expired holds of the payer in the
+// currency. Anyone can call it.
+func (t *CoinChain) ReleaseExpiredHolds(ctx contractapi.TransactionContextInterface, currency string, payer string) ([]*Hold, error) {
+
+	fmt.Println("release expired " + currency + " holds of " + payer)
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	released, err := releaseExpiredHolds(ctx, sheet, payer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(released) == 0 {
+		return released, nil
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(releaseEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	return released, nil
+}
+
+func (t *CoinChain) GetHold(ctx contractapi.TransactionContextInterface, holdId string) (*Hold, error) {
+	return getHold(ctx, holdId)
+}
+
+// HoldsOf returns active holds of the payer.
+func (t *CoinChain) HoldsOf(ctx contractapi.TransactionContextInterface, payer string) ([]*Hold, error) {
+	return getPayerHolds(ctx, payer)
+}
+
+func releaseExpiredHolds(ctx contractapi.TransactionContextInterface, sheet *balanceSheet, payer string) ([]*Hold, error) {
+	holds, err := getPayerHolds(ctx, payer)
+	if err != nil {
+		return nil, err
+	}
+
+	released := []*Hold{}
+	for _, hold := range holds {
+		if hold.Currency != sheet.currency.Symbol {
+			continue
+		}
+
+		expired, err := isExpired(ctx, hold.Expires)
+		if err != nil {
+			return nil, err
+		}
+
+		if !expired {
+			continue
+		}
+
+		err = releaseHold(ctx, sheet, hold)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, hold)
+	}
+
+	return released, nil
+}
+
+// releaseHold returns the held coins and fee to the payer and takes them
+// back from the outflow of the payer.
+func releaseHold(ctx contractapi.TransactionContextInterface, sheet *balanceSheet, hold *Hold) error {
+	held, heldFee, err := heldAmounts(hold, sheet.decimals)
+	if err != nil {
+		return err
+	}
+	held.Add(held, heldFee)
+
+	escrowAccount, err := ctx.GetStub().CreateCompositeKey(escrowAccountType, []string{hold.Payer})
+	if err != nil {
+		return err
+	}
+
+	payerAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{hold.Payer})
+	if err != nil {
+		return err
+	}
+
+	err = sheet.transfer(escrowAccount, payerAccount, held)
+	if err != nil {
+		return err
+	}
+
+	err = returnOutflow(ctx, hold, held)
+	if err != nil {
+		return err
+	}
+
+	hold.Status = releasedStatus
+
+	return closeHold(ctx, hold)
+}
+
+// heldAmounts returns the amount and the fee held by the hold. Holds made
+// before fees were held have no fee.
+func heldAmounts(hold *Hold, decimals int) (*big.Int, *big.Int, error) {
+	held, err := parseAmount(hold.Amount, decimals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(hold.Fee) == 0 {
+		return held, new(big.Int), nil
+	}
+
+	fee, err := parseNonNegativeAmount(hold.Fee, decimals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return held, fee, nil
+}
+
+// checkPayee allows the receiver of the hold to settle it. Holds for
+// accounts which can not sign transactions are settled by an admin.
+func checkPayee(ctx contractapi.TransactionContextInterface, hold *Hold) error {
+	if hold.PayeeType == userAccountType {
+		currentUserId, err := getCurrentUserId(ctx)
+		if err != nil {
+			return err
+		}
+
+		if currentUserId == hold.Payee {
+			return nil
+		}
+	}
+
+	_, err := checkRole(ctx, adminRole)
+	return err
+}
+
+// heldBalance returns the amount of coins of the sheet currency the account
+// has on hold.
+func (s *balanceSheet) heldBalance(account string) (*big.Int, error) {
+	accountType, accountId, err := splitAccount(s.ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountType != userAccountType {
+		return new(big.Int), nil
+	}
+
+	escrowAccount, err := s.ctx.GetStub().CreateCompositeKey(escrowAccountType, []string{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.balanceOf(escrowAccount)
+}
+
+func getHold(ctx contractapi.TransactionContextInterface, holdId string) (*Hold, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(holdPrefix, []string{holdId})
+	if err != nil {
+		return nil, err
+	}
+
+	holdBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(holdBytes) == 0 {
+		return nil, newError(notFoundCode, "hold %s not found", holdId)
+	}
+
+	hold := new(Hold)
+	err = json.Unmarshal(holdBytes, hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func getPayerHolds(ctx contractapi.TransactionContextInterface, payer string) ([]*Hold, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(payerHoldPrefix, []string{payer})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	holds := []*Hold{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		hold, err := getHold(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+
+	return holds, nil
+}
+
+func saveHold(ctx contractapi.TransactionContextInterface, hold *Hold) error {
+	key, err := ctx.GetStub().CreateCompositeKey(holdPrefix, []string{hold.Id})
+	if err != nil {
+		return err
+	}
+
+	holdBytes, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, holdBytes)
+}
+
+// closeHold saves a settled hold and removes it from the active holds of the payer.
+func closeHold(ctx contractapi.TransactionContextInterface, hold *Hold) error {
+	err := saveHold(ctx, hold)
+	if err != nil {
+		return err
+	}
+
+	payerHoldKey, err := ctx.GetStub().CreateCompositeKey(payerHoldPrefix, []string{hold.Payer, hold.Id})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(payerHoldKey)
+}
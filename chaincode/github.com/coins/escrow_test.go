@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEscrow(t *testing.T) {
+	test := newCoinsTest(t)
+	var holdId string
+	hold := func() []string { return []string{holdId} }
+	advance := func(t *testing.T, payload []byte) { test.network.advance(2 * time.Hour) }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "", ""}},
+
+		{name: "hold with bad expiry", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "30", "tomorrow"}, err: invalidArgumentCode},
+		{name: "hold expired", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "30", "2019-12-31T00:00:00Z"}, err: invalidArgumentCode},
+		{name: "hold for yourself", caller: test.alice, function: "Hold", args: []string{"", "user_", "alice", "30", "2020-01-02T00:00:00Z"}, err: invalidReceiverCode},
+		{name: "hold too much", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "200", "2020-01-02T00:00:00Z"}, err: insufficientFundsCode},
+		{name: "hold", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "30", "2020-01-02T00:00:00Z"},
+			want: `{"payer":"alice","payeeType":"user_","payee":"bob","amount":"30","captured":"0","expires":"2020-01-02T00:00:00Z","status":"held"}`, event: holdEventName, check: saveId(&holdId)},
+		{name: "held balance", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"70","held":"30"}`},
+		{name: "holds", caller: test.alice, function: "HoldsOf", args: []string{"alice"}, want: `[{"payee":"bob","amount":"30","status":"held"}]`},
+		{name: "capture by other user", caller: test.carol, function: "Capture", argsOf: func() []string { return []string{holdId, ""} }, err: noPermissionsCode},
+		{name: "capture more than held", caller: test.bob, function: "Capture", argsOf: func() []string { return []string{holdId, "40"} }, err: invalidAmountCode},
+		{name: "capture part", caller: test.bob, function: "Capture", argsOf: func() []string { return []string{holdId, "20"} }, want: `{"captured":"20","status":"captured"}`, event: captureEventName},
+		{name: "capture again", caller: test.bob, function: "Capture", argsOf: func() []string { return []string{holdId, ""} }, err: invalidStateCode},
+		{name: "rest returned", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"80","held":"0"}`},
+		{name: "captured paid", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "bob"}, want: `{"balance":"20"}`},
+		{name: "no active holds", caller: test.alice, function: "HoldsOf", args: []string{"alice"}, want: `[]`},
+
+		{name: "hold for project", caller: test.alice, function: "Hold", args: []string{"", "project_", "p1", "10", "2020-01-02T00:00:00Z"}, check: saveId(&holdId)},
+		{name: "capture by user for project", caller: test.bob, function: "Capture", argsOf: func() []string { return []string{holdId, ""} }, err: noPermissionsCode},
+		{name: "release by payer before expiry", caller: test.alice, function: "Release", argsOf: hold, err: noPermissionsCode},
+		{name: "release by admin", caller: test.admin, function: "Release", argsOf: hold, want: `{"status":"released"}`, event: releaseEventName},
+		{name: "release again", caller: test.admin, function: "Release", argsOf: hold, err: invalidStateCode},
+		{name: "released hold", caller: test.alice, function: "GetHold", argsOf: hold, want: `{"payeeType":"project_","status":"released"}`},
+		{name: "unknown hold", caller: test.alice, function: "GetHold", args: []string{"nope"}, err: notFoundCode},
+
+		{name: "short hold", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "5", "2020-01-01T01:00:00Z"}, check: saveId(&holdId)},
+		{name: "nothing expired", caller: test.carol, function: "ReleaseExpiredHolds", args: []string{"", "alice"}, want: `[]`, check: advance},
+		{name: "capture expired", caller: test.bob, function: "Capture", argsOf: func() []string { return []string{holdId, ""} }, err: invalidStateCode},
+		{name: "release expired by anyone", caller: test.carol, function: "ReleaseExpiredHolds", args: []string{"", "alice"}, want: `[{"payee":"bob","amount":"5","status":"released"}]`, event: releaseEventName},
+		{name: "expired returned", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"80","held":"0"}`},
+
+		{name: "hold expiring", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "5", "2020-01-01T03:00:00Z"}, check: saveId(&holdId)},
+		{name: "expire", caller: test.alice, function: "GetHold", argsOf: hold, want: `{"status":"held"}`, check: advance},
+		{name: "release by payer after expiry", caller: test.alice, function: "Release", argsOf: hold, want: `{"status":"released"}`},
+		{name: "balance after release", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"80","held":"0"}`},
+	})
+}
+
+// The fee of the capture is held with the coins, so the payer can not spend
+// it before the capture, and coins which come back lower the outflow.
+func TestEscrowLimitsAndFees(t *testing.T) {
+	test := newCoinsTest(t)
+	var holdId string
+	hold := func() []string { return []string{holdId} }
+	capture := func(amount string) func() []string { return func() []string { return []string{holdId, amount} } }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "", ""}},
+		{name: "set limits", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":"50","dailyCap":"60"}`}},
+		{name: "set fee", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"1"},"accountTypes":[],"exemptAccountTypes":[]}`}},
+
+		{name: "hold with fee over maximum", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "50", "2020-01-02T00:00:00Z"}, err: limitExceededCode},
+		{name: "hold", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "49", "2020-01-02T00:00:00Z"}, want: `{"amount":"49","fee":"1"}`, check: saveId(&holdId)},
+		{name: "fee held", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"50","held":"50"}`},
+		{name: "fee in escrow audited", caller: test.auditor, function: "AuditSupply", args: []string{""}, want: `{"balanced":true}`},
+		{name: "outflow", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"50"}`},
+		{name: "hold over daily cap", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "10", "2020-01-02T00:00:00Z"}, err: limitExceededCode},
+
+		{name: "capture with fee", caller: test.bob, function: "Capture", argsOf: capture("30"), want: `{"captured":"30","fee":"1","status":"captured"}`, event: captureEventName},
+		{name: "payer paid fee", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"69","held":"0"}`},
+		{name: "payee paid", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "bob"}, want: `{"balance":"30"}`},
+		{name: "treasury", caller: test.alice, function: "BalanceOf", args: []string{"", "treasury_", "main"}, want: `{"balance":"1"}`},
+		{name: "rest off the outflow", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"31"}`},
+
+		{name: "hold to release", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "5", "2020-01-02T00:00:00Z"}, check: saveId(&holdId)},
+		{name: "release", caller: test.bob, function: "Release", argsOf: hold, want: `{"status":"released"}`},
+		{name: "released with fee", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"69","held":"0"}`},
+		{name: "release off the outflow", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"31"}`},
+
+		{name: "hold to drain", caller: test.alice, function: "Hold", args: []string{"", "user_", "bob", "20", "2020-01-02T00:00:00Z"}, check: saveId(&holdId)},
+		{name: "remove limits", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{}`}},
+		{name: "drain", caller: test.alice, function: "Transfer", args: []string{"", "user_", "carol", "47", "", "", ""}, want: `{"balance":"0"}`},
+		{name: "capture after drain", caller: test.bob, function: "Capture", argsOf: capture(""), want: `{"captured":"20","fee":"1","status":"captured"}`},
+		{name: "drained payee paid", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "bob"}, want: `{"balance":"50"}`},
+		{name: "fees paid", caller: test.alice, function: "BalanceOf", args: []string{"", "treasury_", "main"}, want: `{"balance":"3"}`},
+		{name: "supply", caller: test.auditor, function: "AuditSupply", args: []string{""}, want: `{"balanced":true}`},
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Names of the chaincode events. Fabric keeps only one event per transaction,
+// so all coin movements of a transaction are emitted in a single payload.
+var transferEventName = "Transfer"
+var mintEventName = "Mint"
+var burnEventName = "Burn"
+var refundEventName = "Refund"
+
+type TransferEvent struct {
+	Currency  string `json:"currency"`
+	From      string `json:"from"`
+	FromType  string `json:"fromType"`
+	To        string `json:"to"`
+	ToType    string `json:"toType"`
+	Amount    string `json:"amount"`
+	Memo      string `json:"memo,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	Fee       bool   `json:"fee,omitempty"`
+}
+
+type CoinEvent struct {
+	TxId      string           `json:"txId"`
+	Transfers []*TransferEvent `json:"transfers"`
+}
+
+// movement is a single coin movement made by the sheet together with the
+// balances it resulted in. Empty from means the coins were minted, empty to
+// means they were burnt.
+type movement struct {
+	from        string
+	to          string
+	amount      *big.Int
+	fromBalance *big.Int
+	toBalance   *big.Int
+	memo        string
+	reference   string
+	fee         bool
+}
+
+func (s *balanceSheet) record(from string, to string, amount *big.Int, memo string, reference string) error {
+	m := movement{from: from, to: to, amount: amount, memo: memo, reference: reference}
+
+	if from != "" {
+		m.fromBalance = s.balances[from]
+	}
+
+	if to != "" {
+		m.toBalance = s.balances[to]
+	}
+
+	s.movements = append(s.movements, m)
+	return nil
+}
+
+// emit sets the chaincode event with all movements recorded by the sheet.
+func (s *balanceSheet) emit(eventName string) error {
+	return emitAll(s.ctx, eventName, s)
+}
+
+// emitAll sets the chaincode event with all movements recorded by the sheets
+// of a transaction which moves coins of several currencies.
+func emitAll(ctx contractapi.TransactionContextInterface, eventName string, sheets ...*balanceSheet) error {
+	transfers := []*TransferEvent{}
+
+	for _, s := range sheets {
+		sheetTransfers, err := s.transferEvents()
+		if err != nil {
+			return err
+		}
+		transfers = append(transfers, sheetTransfers...)
+	}
+
+	eventBytes, err := json.Marshal(CoinEvent{TxId: ctx.GetStub().GetTxID(), Transfers: transfers})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(eventName, eventBytes)
+}
+
+func (s *balanceSheet) transferEvents() ([]*TransferEvent, error) {
+	transfers := make([]*TransferEvent, 0, len(s.movements))
+
+	for _, m := range s.movements {
+		transfer := &TransferEvent{Currency: s.currency.Symbol, Amount: formatAmount(m.amount, s.decimals), Memo: m.memo, Reference: m.reference, Fee: m.fee}
+
+		if m.from != "" {
+			fromType, from, err := splitAccount(s.ctx, m.from)
+			if err != nil {
+				return nil, err
+			}
+			transfer.FromType = fromType
+			transfer.From = from
+		}
+
+		if m.to != "" {
+			toType, to, err := splitAccount(s.ctx, m.to)
+			if err != nil {
+				return nil, err
+			}
+			transfer.ToType = toType
+			transfer.To = to
+		}
+
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
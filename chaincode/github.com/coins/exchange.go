@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/helper"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Currencies are exchanged at rates set by an admin and stored under
+// rate~<from>~<to>. Exchanged coins are paid into the reserve_exchange
+// account of the source currency and paid out of the reserve_exchange account
+// of the target currency, so the reserve of the target currency must cover
+// every conversion. Reserves are filled by FundReserve and spent like any
+// account which can not sign: an admin approves it with ApproveFor.
+var ratePrefix = "rate"
+var reserveAccountType = "reserve_"
+var exchangeReserve = "exchange"
+
+var exchangeEventName = "Exchange"
+
+// Rates are decimals with up to rateDecimals digits, the amount of the
+// target currency paid for one coin of the source currency.
+var rateDecimals = helper.MaxDecimals
+
+type ExchangeRate struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Rate    string `json:"rate"`
+	Updated string `json:"updated"`
+}
+
+type ExchangeQuote struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Amount  string `json:"amount"`
+	Rate    string `json:"rate"`
+	Out     string `json:"out"`
+	Reserve string `json:"reserve"`
+}
+
+// SetExchangeRate sets how many coins of the to currency one coin of the
+// from currency is exchanged for. The reverse direction has its own rate.
+func (t *CoinChain) SetExchangeRate(ctx contractapi.TransactionContextInterface, from string, to string, rate string) (*ExchangeRate, error) {
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	fromCurrency, toCurrency, err := getCurrencyPair(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(rate, rateDecimals)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "incorrect rate %s", rate)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exchangeRate := &ExchangeRate{
+		From:    fromCurrency.Symbol,
+		To:      toCurrency.Symbol,
+		Rate:    formatAmount(value, rateDecimals),
+		Updated: txTime.Format(time.RFC3339Nano),
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(ratePrefix, []string{exchangeRate.From, exchangeRate.To})
+	if err != nil {
+		return nil, err
+	}
+
+	rateBytes, err := json.Marshal(exchangeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(key, rateBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return exchangeRate, nil
+}
+
+// RemoveExchangeRate stops exchanges in one direction.
+func (t *CoinChain) RemoveExchangeRate(ctx contractapi.TransactionContextInterface, from string, to string) error {
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	fromCurrency, toCurrency, err := getCurrencyPair(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(ratePrefix, []string{fromCurrency.Symbol, toCurrency.Symbol})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+func (t *CoinChain) ExchangeRates(ctx contractapi.TransactionContextInterface) ([]*ExchangeRate, error) {
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(ratePrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	rates := []*ExchangeRate{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		exchangeRate := new(ExchangeRate)
+		err = json.Unmarshal(entry.Value, exchangeRate)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, exchangeRate)
+	}
+
+	return rates, nil
+}
+
+// FundReserve moves amount coins of the current user to the exchange reserve
+// of the currency.
+func (t *CoinChain) FundReserve(ctx contractapi.TransactionContextInterface, currency string, amount string) (*UserBalance, error) {
+
+	fmt.Println("fund " + currency + " reserve, amount " + amount)
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	reserveAccount, err := ctx.GetStub().CreateCompositeKey(reserveAccountType, []string{exchangeReserve})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLimits(ctx, sheet, currentUserAccount, []*big.Int{value}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(currentUserAccount, reserveAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(transferEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Do not invoke BalanceOf method. At this time ledger is not updated yet.
+	return sheet.userBalance(reserveAccount)
+}
+
+// Quote returns the amount of the to currency Exchange would pay for amount
+// coins of the from currency and the reserve available to pay it.
+func (t *CoinChain) Quote(ctx contractapi.TransactionContextInterface, from string, to string, amount string) (*ExchangeQuote, error) {
+
+	fromSheet, toSheet, err := newExchangeSheets(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, fromSheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, _, err := quoteExchange(ctx, fromSheet, toSheet, value)
+	return quote, err
+}
+
+// Exchange converts amount coins of the current user from one currency to
+// another at the current rate. It fails if the converted amount is below
+// minOut, e.g. because the rate changed after the quote.
+func (t *CoinChain) Exchange(ctx contractapi.TransactionContextInterface, from string, to string, amount string, minOut string) (*ExchangeQuote, error) {
+
+	fmt.Println("exchange " + from + " -> " + to)
+
+	fromSheet, toSheet, err := newExchangeSheets(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, fromSheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	minValue, err := parseNonNegativeAmount(minOut, toSheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, out, err := quoteExchange(ctx, fromSheet, toSheet, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Sign() == 0 {
+		return nil, newError(invalidAmountCode, "amount is too small to exchange")
+	}
+
+	if out.Cmp(minValue) < 0 {
+		return nil, newError(slippageCode, "exchange pays %s %s, less than %s", quote.Out, quote.To, minOut)
+	}
+
+	reserve, err := parseNonNegativeAmount(quote.Reserve, toSheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Cmp(reserve) > 0 {
+		return nil, newError(insufficientFundsCode, "%s reserve can not cover the exchange", quote.To)
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	reserveAccount, err := ctx.GetStub().CreateCompositeKey(reserveAccountType, []string{exchangeReserve})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLimits(ctx, fromSheet, currentUserAccount, []*big.Int{value}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fromSheet.transferWithMemo(currentUserAccount, reserveAccount, value, "Exchange", quote.To)
+	if err != nil {
+		return nil, err
+	}
+
+	err = toSheet.transferWithMemo(reserveAccount, currentUserAccount, out, "Exchange", quote.From)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fromSheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = toSheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitAll(ctx, exchangeEventName, fromSheet, toSheet)
+	if err != nil {
+		return nil, err
+	}
+
+	quote.Reserve = formatAmount(new(big.Int).Sub(reserve, out), toSheet.decimals)
+
+	return quote, nil
+}
+
+// quoteExchange converts value base units of the from currency into base units of
+// the to currency, rounding down.
+func quoteExchange(ctx contractapi.TransactionContextInterface, fromSheet *balanceSheet, toSheet *balanceSheet, value *big.Int) (*ExchangeQuote, *big.Int, error) {
+
+	key, err := ctx.GetStub().CreateCompositeKey(ratePrefix, []string{fromSheet.currency.Symbol, toSheet.currency.Symbol})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rateBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(rateBytes) == 0 {
+		return nil, nil, newError(notFoundCode, "no exchange rate from %s to %s", fromSheet.currency.Symbol, toSheet.currency.Symbol)
+	}
+
+	exchangeRate := new(ExchangeRate)
+	err = json.Unmarshal(rateBytes, exchangeRate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rate, err := parseAmount(exchangeRate.Rate, rateDecimals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// out = value * rate * 10^toDecimals / (10^fromDecimals * 10^rateDecimals)
+	out := new(big.Int).Mul(value, rate)
+	out.Mul(out, pow10(toSheet.decimals))
+	out.Quo(out, new(big.Int).Mul(pow10(fromSheet.decimals), pow10(rateDecimals)))
+
+	reserveAccount, err := ctx.GetStub().CreateCompositeKey(reserveAccountType, []string{exchangeReserve})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reserve, err := toSheet.balanceOf(reserveAccount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &ExchangeQuote{
+		From:    fromSheet.currency.Symbol,
+		To:      toSheet.currency.Symbol,
+		Amount:  formatAmount(value, fromSheet.decimals),
+		Rate:    exchangeRate.Rate,
+		Out:     formatAmount(out, toSheet.decimals),
+		Reserve: formatAmount(reserve, toSheet.decimals),
+	}, out, nil
+}
+
+func newExchangeSheets(ctx contractapi.TransactionContextInterface, from string, to string) (*balanceSheet, *balanceSheet, error) {
+
+	fromCurrency, toCurrency, err := getCurrencyPair(ctx, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fromSheet, err := newBalanceSheet(ctx, fromCurrency.Symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toSheet, err := newBalanceSheet(ctx, toCurrency.Symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fromSheet, toSheet, nil
+}
+
+func getCurrencyPair(ctx contractapi.TransactionContextInterface, from string, to string) (*Currency, *Currency, error) {
+
+	fromCurrency, err := getCurrency(ctx, from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toCurrency, err := getCurrency(ctx, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if fromCurrency.Symbol == toCurrency.Symbol {
+		return nil, nil, newError(invalidArgumentCode, "can not exchange %s to itself", fromCurrency.Symbol)
+	}
+
+	return fromCurrency, toCurrency, nil
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
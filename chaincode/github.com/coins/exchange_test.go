@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExchange(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "create currency", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}},
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "", ""}},
+		{name: "mint target currency", caller: test.carol, function: "Mint", args: []string{"EUR", "50"}},
+		{name: "fund reserve", caller: test.carol, function: "FundReserve", args: []string{"EUR", "40"}, want: `{"userId":` + jsonString(account(reserveAccountType, exchangeReserve)) + `,"balance":"40"}`, event: transferEventName},
+		{name: "fund reserve beyond balance", caller: test.carol, function: "FundReserve", args: []string{"EUR", "20"}, err: insufficientFundsCode},
+
+		{name: "set rate by user", caller: test.alice, function: "SetExchangeRate", args: []string{"SJ", "EUR", "0.5"}, err: noPermissionsCode},
+		{name: "set rate to itself", caller: test.admin, function: "SetExchangeRate", args: []string{"SJ", "SJ", "1"}, err: invalidArgumentCode},
+		{name: "set rate of unknown currency", caller: test.admin, function: "SetExchangeRate", args: []string{"SJ", "XX", "1"}, err: notFoundCode},
+		{name: "set bad rate", caller: test.admin, function: "SetExchangeRate", args: []string{"SJ", "EUR", "abc"}, err: invalidArgumentCode},
+		{name: "set rate", caller: test.admin, function: "SetExchangeRate", args: []string{"", "EUR", "0.50"}, want: `{"from":"SJ","to":"EUR","rate":"0.5","updated":"2020-01-01T00:00:00Z"}`},
+		{name: "set reverse rate", caller: test.admin, function: "SetExchangeRate", args: []string{"EUR", "SJ", "2"}},
+		{name: "rates", caller: test.alice, function: "ExchangeRates", want: `[{"from":"EUR","to":"SJ","rate":"2"},{"from":"SJ","to":"EUR","rate":"0.5"}]`},
+
+		{name: "quote", caller: test.alice, function: "Quote", args: []string{"SJ", "EUR", "10"}, want: `{"from":"SJ","to":"EUR","amount":"10","rate":"0.5","out":"5","reserve":"40"}`},
+		{name: "quote without reserve", caller: test.alice, function: "Quote", args: []string{"EUR", "SJ", "1"}, want: `{"out":"2","reserve":"0"}`},
+		{name: "exchange below min out", caller: test.alice, function: "Exchange", args: []string{"SJ", "EUR", "10", "6"}, err: slippageCode},
+		{name: "exchange too small", caller: test.alice, function: "Exchange", args: []string{"SJ", "EUR", "0.01", "0"}, err: invalidAmountCode},
+		{name: "exchange beyond reserve", caller: test.alice, function: "Exchange", args: []string{"SJ", "EUR", "90", "0"}, err: insufficientFundsCode},
+		{name: "exchange beyond balance", caller: test.bob, function: "Exchange", args: []string{"SJ", "EUR", "10", "0"}, err: insufficientFundsCode},
+		{name: "exchange", caller: test.alice, function: "Exchange", args: []string{"SJ", "EUR", "10", "5"}, want: `{"out":"5","reserve":"35"}`, event: exchangeEventName},
+		{name: "paid in source currency", caller: test.alice, function: "BalanceOf", args: []string{"SJ", "user_", "alice"}, want: `{"balance":"90"}`},
+		{name: "received target currency", caller: test.alice, function: "BalanceOf", args: []string{"EUR", "user_", "alice"}, want: `{"balance":"5"}`},
+		{name: "source reserve", caller: test.alice, function: "BalanceOf", args: []string{"SJ", "reserve_", "exchange"}, want: `{"balance":"10"}`},
+
+		{name: "remove rate by user", caller: test.alice, function: "RemoveExchangeRate", args: []string{"SJ", "EUR"}, err: noPermissionsCode},
+		{name: "remove rate", caller: test.admin, function: "RemoveExchangeRate", args: []string{"SJ", "EUR"}},
+		{name: "quote without rate", caller: test.alice, function: "Quote", args: []string{"SJ", "EUR", "10"}, err: notFoundCode},
+		{name: "exchange without rate", caller: test.alice, function: "Exchange", args: []string{"SJ", "EUR", "10", "0"}, err: notFoundCode},
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transfer fees are paid by the sender on top of the amount and collected on
+// the treasury_<treasury> account. Each currency has its own fee policy
+// stored under feePolicy~<currency>. The treasury is spent like any account
+// which can not sign: an admin approves it with ApproveFor.
+var feePolicyKey = "feePolicy"
+var treasuryAccountType = "treasury_"
+var defaultTreasury = "main"
+
+// FeeRule charges Flat coins plus BasisPoints hundredths of a percent of the
+// transferred amount.
+type FeeRule struct {
+	Flat        string `json:"flat"`
+	BasisPoints int    `json:"basisPoints"`
+}
+
+type AccountTypeFee struct {
+	AccountType string  `json:"accountType"`
+	Fee         FeeRule `json:"fee"`
+}
+
+// FeePolicy applies the fee of the receiver account type or the default fee.
+// Transfers from the minter of the currency and transfers from or to exempt account types
+// are free.
+type FeePolicy struct {
+	Default            FeeRule          `json:"default"`
+	AccountTypes       []AccountTypeFee `json:"accountTypes"`
+	ExemptAccountTypes []string         `json:"exemptAccountTypes"`
+	Treasury           string           `json:"treasury"`
+}
+
+// SetFeePolicy replaces the fee policy, e.g.
+// {"default": {"flat": "0.5", "basisPoints": 100}, "exemptAccountTypes": ["project_"]}
+func (t *CoinChain) SetFeePolicy(ctx contractapi.TransactionContextInterface, currency string, policyJson string) (*FeePolicy, error) {
+
+	fmt.Println("fee policy of " + currency + " json: " + policyJson)
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(FeePolicy)
+	err = json.Unmarshal([]byte(policyJson), policy)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "incorrect fee policy: %s", err.Error())
+	}
+
+	if len(policy.Treasury) == 0 {
+		policy.Treasury = defaultTreasury
+	}
+
+	if policy.AccountTypes == nil {
+		policy.AccountTypes = []AccountTypeFee{}
+	}
+
+	if policy.ExemptAccountTypes == nil {
+		policy.ExemptAccountTypes = []string{projectAccountType}
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []FeeRule{policy.Default}
+	for _, accountTypeFee := range policy.AccountTypes {
+		rules = append(rules, accountTypeFee.Fee)
+	}
+
+	for _, rule := range rules {
+		err = validateFeeRule(rule, currencyInfo.Decimals)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(feePolicyKey, []string{currencyInfo.Symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(key, policyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (t *CoinChain) GetFeePolicy(ctx contractapi.TransactionContextInterface, currency string) (*FeePolicy, error) {
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return getFeePolicy(ctx, currencyInfo.Symbol)
+}
+
+// chargeFee moves the transfer fee from the sender to the treasury and
+// returns it.
+func (s *balanceSheet) chargeFee(policy *FeePolicy, from string, to string, amount *big.Int) (*big.Int, error) {
+	fee, err := s.feeOf(policy, from, to, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.payFee(policy, from, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	return fee, nil
+}
+
+// payFee moves a fee from the account to the treasury of the policy. The fee
+// movement is marked as a fee in the event.
+func (s *balanceSheet) payFee(policy *FeePolicy, from string, fee *big.Int) error {
+	if fee.Sign() == 0 {
+		return nil
+	}
+
+	treasuryAccount, err := s.ctx.GetStub().CreateCompositeKey(treasuryAccountType, []string{policy.Treasury})
+	if err != nil {
+		return err
+	}
+
+	err = s.transfer(from, treasuryAccount, fee)
+	if err != nil {
+		return err
+	}
+
+	s.movements[len(s.movements)-1].fee = true
+
+	return nil
+}
+
+// feeOf calculates the fee of a transfer without charging it.
+func (s *balanceSheet) feeOf(policy *FeePolicy, from string, to string, amount *big.Int) (*big.Int, error) {
+	fromType, fromId, err := splitAccount(s.ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	toType, _, err := splitAccount(s.ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, exemptType := range policy.ExemptAccountTypes {
+		if fromType == exemptType || toType == exemptType {
+			return new(big.Int), nil
+		}
+	}
+
+	if fromType == userAccountType && fromId == s.currency.Minter {
+		return new(big.Int), nil
+	}
+
+	rule := policy.Default
+	for _, accountTypeFee := range policy.AccountTypes {
+		if accountTypeFee.AccountType == toType {
+			rule = accountTypeFee.Fee
+		}
+	}
+
+	fee := new(big.Int)
+	if len(rule.Flat) != 0 {
+		fee, err = parseNonNegativeAmount(rule.Flat, s.decimals)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	percentage := new(big.Int).Mul(amount, big.NewInt(int64(rule.BasisPoints)))
+	percentage.Quo(percentage, big.NewInt(10000))
+
+	return fee.Add(fee, percentage), nil
+}
+
+func validateFeeRule(rule FeeRule, decimals int) error {
+	if rule.BasisPoints < 0 || rule.BasisPoints > 10000 {
+		return newError(invalidArgumentCode, "incorrect fee basis points %d", rule.BasisPoints)
+	}
+
+	if len(rule.Flat) != 0 {
+		_, err := parseNonNegativeAmount(rule.Flat, decimals)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getFeePolicy(ctx contractapi.TransactionContextInterface, symbol string) (*FeePolicy, error) {
+
+	key, err := ctx.GetStub().CreateCompositeKey(feePolicyKey, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	policyBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(FeePolicy)
+	policy.AccountTypes = []AccountTypeFee{}
+	policy.ExemptAccountTypes = []string{projectAccountType}
+	policy.Treasury = defaultTreasury
+
+	if len(policyBytes) == 0 {
+		return policy, nil
+	}
+
+	err = json.Unmarshal(policyBytes, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
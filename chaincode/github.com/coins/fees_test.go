@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestFees(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "1000"}},
+		{name: "minter pays no fee", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "", ""}, want: `{"balance":"900","fee":"0"}`},
+
+		{name: "default policy", caller: test.alice, function: "GetFeePolicy", args: []string{""},
+			want: `{"default":{"flat":"","basisPoints":0},"accountTypes":[],"exemptAccountTypes":["project_"],"treasury":"main"}`},
+		{name: "set by user", caller: test.alice, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"1"}}`}, err: noPermissionsCode},
+		{name: "set malformed", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":1}`}, err: invalidArgumentCode},
+		{name: "set without account types", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"0.5","basisPoints":100},"exemptAccountTypes":["project_"]}`},
+			want: `{"default":{"flat":"0.5","basisPoints":100},"accountTypes":[],"exemptAccountTypes":["project_"],"treasury":"main"}`},
+		{name: "set bad basis points", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"basisPoints":20000}}`}, err: invalidArgumentCode},
+		{name: "set bad flat fee", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"0.001"}}`}, err: invalidAmountCode},
+		{name: "set", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"0.5","basisPoints":100},"accountTypes":[{"accountType":"foundation_","fee":{"flat":"0","basisPoints":0}}]}`},
+			want: `{"default":{"flat":"0.5","basisPoints":100},"exemptAccountTypes":["project_"],"treasury":"main"}`},
+		{name: "policy", caller: test.alice, function: "GetFeePolicy", args: []string{""}, want: `{"default":{"flat":"0.5","basisPoints":100},"treasury":"main"}`},
+
+		{name: "transfer with fee", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "10", "", "", ""}, want: `{"balance":"89.4","fee":"0.6"}`,
+			check: func(t *testing.T, payload []byte) {
+				event := new(CoinEvent)
+				test.eventOf(t, event)
+				assertContains(t, `[{"to":"bob","amount":"10"},{"to":"main","toType":"treasury_","amount":"0.6","fee":true}]`, mustMarshal(t, event.Transfers))
+			}},
+		{name: "transfer to exempt type", caller: test.alice, function: "Transfer", args: []string{"", "project_", "p1", "10", "", "", ""}, want: `{"balance":"79.4","fee":"0"}`},
+		{name: "transfer with account type fee", caller: test.alice, function: "Transfer", args: []string{"", "foundation_", "f1", "10", "", "", ""}, want: `{"balance":"69.4","fee":"0"}`},
+		{name: "batch transfer with fees", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"10"},{"userId":"carol","amount":"20"}]`, ""}, want: `{"balance":"38.1","fee":"1.3"}`},
+		{name: "fee exceeds balance", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "38", "", "", ""}, err: insufficientFundsCode},
+		{name: "treasury", caller: test.alice, function: "BalanceOf", args: []string{"", "treasury_", "main"}, want: `{"balance":"1.9"}`},
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+var foundationChaincode = "foundation"
+
+// mockFoundationChaincode makes the coins calls of the foundation chaincode
+// (chaincode/github.com/foundation) with the same arguments: donate pays the
+// caller's coins to the foundation account, withdraw (also used to return
+// donations on close) pays coins of the foundation account by TransferFrom.
+type mockFoundationChaincode struct {
+}
+
+func (c *mockFoundationChaincode) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (c *mockFoundationChaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	var coinsArgs []string
+	if function == "donate" && len(args) == 3 {
+		// currency, amount, foundation name
+		coinsArgs = []string{"Transfer", args[0], "foundation_", args[2], args[1], "", "Donation", ""}
+	} else if function == "withdraw" && len(args) == 4 {
+		// currency, foundation name, receiver ID, amount
+		coinsArgs = []string{"TransferFrom", args[0], "foundation_", args[1], "user_", args[2], args[3]}
+	} else {
+		return shim.Error("Invalid invoke function name.")
+	}
+
+	byteArgs := make([][]byte, 0, len(coinsArgs))
+	for _, arg := range coinsArgs {
+		byteArgs = append(byteArgs, []byte(arg))
+	}
+
+	response := stub.InvokeChaincode(coinsChaincode, byteArgs, mockChannel)
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+
+	return shim.Success(response.Payload)
+}
+
+func TestFoundationPath(t *testing.T) {
+	test := newCoinsTest(t)
+	test.network.register(foundationChaincode, new(mockFoundationChaincode))
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "50", "", "", ""}},
+
+		{name: "donate", caller: test.alice, chaincode: foundationChaincode, function: "donate", args: []string{"", "10", "charity"}, want: `{"balance":"40"}`},
+		{name: "collected", caller: test.alice, function: "BalanceOf", args: []string{"", "foundation_", "charity"}, want: `{"balance":"10"}`},
+		{name: "donate too much", caller: test.alice, chaincode: foundationChaincode, function: "donate", args: []string{"", "100", "charity"}, err: insufficientFundsCode},
+
+		{name: "withdraw without allowance", caller: test.bob, chaincode: foundationChaincode, function: "withdraw", args: []string{"", "charity", "alice", "4"}, err: insufficientAllowanceCode},
+		{name: "allow foundation admin", caller: test.admin, function: "ApproveFor", args: []string{"", "foundation_", "charity", "user_", "bob", "10"}},
+		{name: "withdraw", caller: test.bob, chaincode: foundationChaincode, function: "withdraw", args: []string{"", "charity", "alice", "4"}, want: `{"balance":"6"}`, event: transferEventName},
+		{name: "return donation", caller: test.bob, chaincode: foundationChaincode, function: "withdraw", args: []string{"", "charity", "carol", "6"}, want: `{"balance":"0"}`},
+		{name: "withdraw beyond allowance", caller: test.bob, chaincode: foundationChaincode, function: "withdraw", args: []string{"", "charity", "alice", "1"}, err: insufficientAllowanceCode},
+		{name: "paid out", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"44"}`},
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every coin movement is appended to the journal of both accounts under
+// journal~<account type>~<account id>~<currency>~<tx timestamp>~<tx id>~<index>,
+// so the history of an account in a currency can be read page by page in
+// time order.
+var journalPrefix = "journal"
+
+var incomingDirection = "in"
+var outgoingDirection = "out"
+
+type HistoryRecord struct {
+	Currency         string `json:"currency"`
+	Timestamp        string `json:"timestamp"`
+	TxId             string `json:"txId"`
+	Counterparty     string `json:"counterparty"`
+	CounterpartyType string `json:"counterpartyType"`
+	Direction        string `json:"direction"`
+	Amount           string `json:"amount"`
+	Balance          string `json:"balance"`
+	Memo             string `json:"memo,omitempty" metadata:"memo,optional"`
+	Reference        string `json:"reference,omitempty" metadata:"reference,optional"`
+}
+
+type HistoryPage struct {
+	Records  []*HistoryRecord `json:"records"`
+	Bookmark string           `json:"bookmark"`
+}
+
+// HistoryOf returns coin movements of the account in the currency (the
+// default currency if empty), oldest first. Pass the
+// bookmark of the previous page to get the next one. Users can read their own
+// history, auditors can read history of any account. A non-empty search
+// keeps movements with exactly this reference or with a memo containing it
+// (case insensitive), so pages can hold fewer than pageSize records.
+func (t *CoinChain) HistoryOf(ctx contractapi.TransactionContextInterface, currency string, accountType string, accountId string, pageSize int32, bookmark string, search string) (*HistoryPage, error) {
+
+	fmt.Println("account " + accountType + accountId)
+	fmt.Println("search " + search)
+
+	if pageSize <= 0 {
+		return nil, newError(invalidArgumentCode, "incorrect page size %d", pageSize)
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountType != userAccountType || accountId != currentUserId {
+		_, err = checkRole(ctx, auditorRole)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(journalPrefix, []string{accountType, accountId, currencyInfo.Symbol}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	page := new(HistoryPage)
+	page.Records = []*HistoryRecord{}
+
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		record := new(HistoryRecord)
+		err = json.Unmarshal(entry.Value, record)
+		if err != nil {
+			return nil, err
+		}
+
+		if !record.matches(search) {
+			continue
+		}
+
+		page.Records = append(page.Records, record)
+	}
+
+	page.Bookmark = metadata.Bookmark
+
+	return page, nil
+}
+
+// saveJournal writes movements recorded since the last save to the journal.
+func (s *balanceSheet) saveJournal() error {
+	timestamp, err := getTxTime(s.ctx)
+	if err != nil {
+		return err
+	}
+	txId := s.ctx.GetStub().GetTxID()
+
+	for i := s.journaled; i < len(s.movements); i++ {
+		m := s.movements[i]
+
+		if m.from != "" {
+			err = s.journal(m.from, m.to, outgoingDirection, m, m.fromBalance, timestamp, txId, i)
+			if err != nil {
+				return err
+			}
+		}
+
+		if m.to != "" {
+			err = s.journal(m.to, m.from, incomingDirection, m, m.toBalance, timestamp, txId, i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *balanceSheet) journal(account string, counterparty string, direction string, m movement, balance *big.Int, timestamp time.Time, txId string, index int) error {
+	accountType, accountId, err := splitAccount(s.ctx, account)
+	if err != nil {
+		return err
+	}
+
+	record := &HistoryRecord{
+		Currency:  s.currency.Symbol,
+		Timestamp: timestamp.Format(time.RFC3339Nano),
+		TxId:      txId,
+		Direction: direction,
+		Amount:    formatAmount(m.amount, s.decimals),
+		Balance:   formatAmount(balance, s.decimals),
+		Memo:      m.memo,
+		Reference: m.reference,
+	}
+
+	if counterparty != "" {
+		record.CounterpartyType, record.Counterparty, err = splitAccount(s.ctx, counterparty)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := s.ctx.GetStub().CreateCompositeKey(journalPrefix, []string{
+		accountType, accountId, s.currency.Symbol, fmt.Sprintf("%020d", timestamp.UnixNano()), txId, fmt.Sprintf("%06d", index),
+	})
+	if err != nil {
+		return err
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.ctx.GetStub().PutState(key, recordBytes)
+}
+
+func (r *HistoryRecord) matches(search string) bool {
+	if len(search) == 0 || r.Reference == search {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(r.Memo), strings.ToLower(search))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	test := newCoinsTest(t)
+	nextMinute := func(t *testing.T, payload []byte) { test.network.advance(time.Minute) }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}, check: nextMinute},
+		{name: "transfer to alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "30", "", "Happy birthday", "order-42"}, check: nextMinute},
+		{name: "transfer to bob", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "10", "", "lunch", ""}},
+
+		{name: "history", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "10", "", ""},
+			want: `{"records":[
+				{"timestamp":"2020-01-01T00:01:00Z","counterparty":"sj_coin","counterpartyType":"user_","direction":"in","amount":"30","balance":"30","memo":"Happy birthday","reference":"order-42"},
+				{"timestamp":"2020-01-01T00:02:00Z","counterparty":"bob","counterpartyType":"user_","direction":"out","amount":"10","balance":"20","memo":"lunch"}
+			],"bookmark":""}`},
+		{name: "history of others", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "bob", "10", "", ""}, err: noPermissionsCode},
+		{name: "history by auditor", caller: test.auditor, function: "HistoryOf", args: []string{"", "user_", "bob", "10", "", ""},
+			want: `{"records":[{"counterparty":"alice","direction":"in","amount":"10","balance":"10"}]}`},
+		{name: "mint history", caller: test.auditor, function: "HistoryOf", args: []string{"", "user_", "sj_coin", "1", "", ""},
+			want: `{"records":[{"counterparty":"","counterpartyType":"","direction":"in","amount":"100","balance":"100"}]}`},
+		{name: "unknown currency", caller: test.alice, function: "HistoryOf", args: []string{"XX", "user_", "alice", "10", "", ""}, err: notFoundCode},
+		{name: "history in currency", caller: test.alice, function: "HistoryOf", args: []string{"SJ", "user_", "alice", "10", "", ""}, want: `{"records":[{"amount":"30"},{"amount":"10"}]}`},
+		{name: "create currency", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}},
+		{name: "mint other currency", caller: test.carol, function: "Mint", args: []string{"EUR", "10"}},
+		{name: "pay alice other currency", caller: test.carol, function: "Transfer", args: []string{"EUR", "user_", "alice", "4", "", "", ""}},
+		{name: "full page in other currency", caller: test.alice, function: "HistoryOf", args: []string{"EUR", "user_", "alice", "1", "", ""},
+			want: `{"records":[{"currency":"EUR","counterparty":"carol","amount":"4","balance":"4"}],"bookmark":""}`},
+		{name: "default currency only", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "10", "", ""}, want: `{"records":[{"currency":"SJ"},{"currency":"SJ"}]}`},
+		{name: "bad page size", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "0", "", ""}, err: invalidArgumentCode},
+		{name: "search memo", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "10", "", "BIRTHDAY"}, want: `{"records":[{"amount":"30"}]}`},
+		{name: "search reference", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "10", "", "order-42"}, want: `{"records":[{"amount":"30"}]}`},
+		{name: "search without match", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "10", "", "rent"}, want: `{"records":[]}`},
+		{name: "pages", caller: test.alice, function: "HistoryOf", args: []string{"", "user_", "alice", "1", "", ""}, want: `{"records":[{"amount":"30"}]}`,
+			check: func(t *testing.T, payload []byte) {
+				page := new(HistoryPage)
+				mustUnmarshal(t, payload, page)
+
+				if len(page.Bookmark) == 0 {
+					t.Fatal("no bookmark of the next page")
+				}
+
+				response := test.network.invoke(test.alice, coinsChaincode, "HistoryOf", "", "user_", "alice", "1", page.Bookmark, "")
+				assertContains(t, `{"records":[{"amount":"10"}],"bookmark":""}`, response.Payload)
+			}},
+	})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Hashed time-locked transfers for atomic swaps with other chaincodes. Locked
+// coins wait on the htlc_<lock id> account until the receiver's side reveals
+// the preimage of the hashlock or the timeout passes. Locks are stored under
+// lock~<lock id> and indexed by hashlock under lockHash~<hashlock>~<lock id>,
+// so the counterparty can find the lock of a swap.
+var lockAccountType = "htlc_"
+var lockPrefix = "lock"
+var lockHashPrefix = "lockHash"
+
+var lockEventName = "Lock"
+var claimEventName = "Claim"
+var lockRefundEventName = "LockRefund"
+
+var lockedStatus = "locked"
+var claimedStatus = "claimed"
+var refundedStatus = "refunded"
+
+// Lock hashes are hex encoded SHA-256 hashes, preimages are hex encoded bytes.
+type Lock struct {
+	Id       string `json:"id"`
+	Currency string `json:"currency"`
+	Sender   string `json:"sender"`
+	Receiver string `json:"receiver"`
+	Amount   string `json:"amount"`
+	Hashlock string `json:"hashlock"`
+	Preimage string `json:"preimage,omitempty" metadata:"preimage,optional"`
+	Timeout  string `json:"timeout"`
+	Status   string `json:"status"`
+	Created  string `json:"created"`
+}
+
+// LockEvent is the payload of Lock, Claim and LockRefund events. The Claim
+// event reveals the preimage to the counterparty.
+type LockEvent struct {
+	TxId      string           `json:"txId"`
+	Lock      *Lock            `json:"lock"`
+	Transfers []*TransferEvent `json:"transfers"`
+}
+
+// LockWithHash locks amount coins of the current user for the receiver until
+// the timeout (RFC3339). The receiver gets them by ClaimWithPreimage before
+// the timeout, otherwise they go back by RefundExpired. The transaction ID
+// is the lock ID.
+func (t *CoinChain) LockWithHash(ctx contractapi.TransactionContextInterface, currency string, receiver string, amount string, hashlock string, timeout string) (*Lock, error) {
+
+	fmt.Println("lock for " + receiver)
+
+	err := validateReceiver(userAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	hashBytes, err := hex.DecodeString(hashlock)
+	if err != nil || len(hashBytes) != sha256.Size {
+		return nil, newError(invalidArgumentCode, "hashlock must be a hex encoded SHA-256 hash")
+	}
+
+	timeoutTime, err := time.Parse(time.RFC3339Nano, timeout)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "incorrect timeout %s", timeout)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !timeoutTime.After(txTime) {
+		return nil, newError(invalidArgumentCode, "timeout must be in the future")
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if receiver == currentUserId {
+		return nil, newError(invalidReceiverCode, "can not lock coins for yourself")
+	}
+
+	err = checkReceiver(ctx, userAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{
+		Id:       ctx.GetStub().GetTxID(),
+		Currency: sheet.currency.Symbol,
+		Sender:   currentUserId,
+		Receiver: receiver,
+		Amount:   formatAmount(value, sheet.decimals),
+		Hashlock: hex.EncodeToString(hashBytes),
+		Timeout:  timeoutTime.UTC().Format(time.RFC3339Nano),
+		Status:   lockedStatus,
+		Created:  txTime.Format(time.RFC3339Nano),
+	}
+
+	lockAccount, err := ctx.GetStub().CreateCompositeKey(lockAccountType, []string{lock.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLimits(ctx, sheet, currentUserAccount, []*big.Int{value}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(currentUserAccount, lockAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveLock(ctx, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	lockHashKey, err := ctx.GetStub().CreateCompositeKey(lockHashPrefix, []string{lock.Hashlock, lock.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(lockHashKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emitLock(lockEventName, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// ClaimWithPreimage pays the locked coins to the receiver if the SHA-256 hash
+// of the hex encoded preimage matches the hashlock. Anyone knowing the
+// preimage can claim before the timeout, the coins always go to the receiver.
+func (t *CoinChain) ClaimWithPreimage(ctx contractapi.TransactionContextInterface, lockId string, preimage string) (*Lock, error) {
+
+	lock, err := getLock(ctx, lockId)
+	if err != nil {
+		return nil, err
+	}
+
+	if lock.Status != lockedStatus {
+		return nil, newError(invalidStateCode, "lock is %s", lock.Status)
+	}
+
+	expired, err := isExpired(ctx, lock.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, newError(invalidStateCode, "lock is expired")
+	}
+
+	preimageBytes, err := hex.DecodeString(preimage)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "preimage must be hex encoded")
+	}
+
+	hash := sha256.Sum256(preimageBytes)
+	if hex.EncodeToString(hash[:]) != lock.Hashlock {
+		return nil, newError(invalidArgumentCode, "preimage does not match the hashlock")
+	}
+
+	receiverAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{lock.Receiver})
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Preimage = hex.EncodeToString(preimageBytes)
+	lock.Status = claimedStatus
+
+	return settleLock(ctx, lock, receiverAccount, claimEventName)
+}
+
+// RefundExpired returns the locked coins to the sender once the timeout has
+// passed. Anyone can call it.
+func (t *CoinChain) RefundExpired(ctx contractapi.TransactionContextInterface, lockId string) (*Lock, error) {
+
+	lock, err := getLock(ctx, lockId)
+	if err != nil {
+		return nil, err
+	}
+
+	if lock.Status != lockedStatus {
+		return nil, newError(invalidStateCode, "lock is %s", lock.Status)
+	}
+
+	expired, err := isExpired(ctx, lock.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if !expired {
+		return nil, newError(invalidStateCode, "lock is not expired yet")
+	}
+
+	senderAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{lock.Sender})
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Status = refundedStatus
+
+	return settleLock(ctx, lock, senderAccount, lockRefundEventName)
+}
+
+func (t *CoinChain) GetLock(ctx contractapi.TransactionContextInterface, lockId string) (*Lock, error) {
+	return getLock(ctx, lockId)
+}
+
+// LocksByHash returns all locks with the hashlock, e.g. the lock of the
+// counterparty of a swap.
+func (t *CoinChain) LocksByHash(ctx contractapi.TransactionContextInterface, hashlock string) ([]*Lock, error) {
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(lockHashPrefix, []string{hashlock})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	locks := []*Lock{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		lock, err := getLock(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+
+	return locks, nil
+}
+
+// settleLock moves the locked coins to the account and saves the lock.
+func settleLock(ctx contractapi.TransactionContextInterface, lock *Lock, account string, eventName string) (*Lock, error) {
+
+	sheet, err := newBalanceSheet(ctx, lock.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(lock.Amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	lockAccount, err := ctx.GetStub().CreateCompositeKey(lockAccountType, []string{lock.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(lockAccount, account, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveLock(ctx, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emitLock(eventName, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// emitLock sets the chaincode event with the lock and the movements recorded
+// by the sheet.
+func (s *balanceSheet) emitLock(eventName string, lock *Lock) error {
+	transfers, err := s.transferEvents()
+	if err != nil {
+		return err
+	}
+
+	eventBytes, err := json.Marshal(LockEvent{TxId: s.ctx.GetStub().GetTxID(), Lock: lock, Transfers: transfers})
+	if err != nil {
+		return err
+	}
+
+	return s.ctx.GetStub().SetEvent(eventName, eventBytes)
+}
+
+func getLock(ctx contractapi.TransactionContextInterface, lockId string) (*Lock, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(lockPrefix, []string{lockId})
+	if err != nil {
+		return nil, err
+	}
+
+	lockBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lockBytes) == 0 {
+		return nil, newError(notFoundCode, "lock %s not found", lockId)
+	}
+
+	lock := new(Lock)
+	err = json.Unmarshal(lockBytes, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+func saveLock(ctx contractapi.TransactionContextInterface, lock *Lock) error {
+	key, err := ctx.GetStub().CreateCompositeKey(lockPrefix, []string{lock.Id})
+	if err != nil {
+		return err
+	}
+
+	lockBytes, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, lockBytes)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestAtomicSwap(t *testing.T) {
+	test := newCoinsTest(t)
+
+	preimage := hex.EncodeToString([]byte("secret"))
+	hash := sha256.Sum256([]byte("secret"))
+	hashlock := hex.EncodeToString(hash[:])
+
+	var aliceLockId, bobLockId string
+	aliceLock := func() []string { return []string{aliceLockId} }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "", ""}},
+		{name: "create currency", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}},
+		{name: "mint currency", caller: test.carol, function: "Mint", args: []string{"EUR", "50"}},
+		{name: "pay bob", caller: test.carol, function: "Transfer", args: []string{"EUR", "user_", "bob", "50", "", "", ""}},
+
+		{name: "lock with bad hashlock", caller: test.alice, function: "LockWithHash", args: []string{"", "bob", "30", "abcd", "2020-01-02T00:00:00Z"}, err: invalidArgumentCode},
+		{name: "lock with bad timeout", caller: test.alice, function: "LockWithHash", args: []string{"", "bob", "30", hashlock, "later"}, err: invalidArgumentCode},
+		{name: "lock expired", caller: test.alice, function: "LockWithHash", args: []string{"", "bob", "30", hashlock, "2019-01-01T00:00:00Z"}, err: invalidArgumentCode},
+		{name: "lock for yourself", caller: test.alice, function: "LockWithHash", args: []string{"", "alice", "30", hashlock, "2020-01-02T00:00:00Z"}, err: invalidReceiverCode},
+		{name: "lock too much", caller: test.alice, function: "LockWithHash", args: []string{"", "bob", "300", hashlock, "2020-01-02T00:00:00Z"}, err: insufficientFundsCode},
+		{name: "lock", caller: test.alice, function: "LockWithHash", args: []string{"", "bob", "30", hashlock, "2020-01-02T00:00:00Z"},
+			want: `{"currency":"SJ","sender":"alice","receiver":"bob","amount":"30","hashlock":"` + hashlock + `","timeout":"2020-01-02T00:00:00Z","status":"locked"}`, event: lockEventName, check: saveId(&aliceLockId)},
+		{name: "counter lock", caller: test.bob, function: "LockWithHash", args: []string{"EUR", "alice", "20", hashlock, "2020-01-01T12:00:00Z"}, event: lockEventName, check: saveId(&bobLockId)},
+		{name: "locks by hash", caller: test.bob, function: "LocksByHash", args: []string{hashlock}, want: `[{"status":"locked"},{"status":"locked"}]`},
+		{name: "locked balance", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"70"}`},
+
+		{name: "claim with wrong preimage", caller: test.alice, function: "ClaimWithPreimage", argsOf: func() []string { return []string{bobLockId, "00"} }, err: invalidArgumentCode},
+		{name: "claim with bad preimage", caller: test.alice, function: "ClaimWithPreimage", argsOf: func() []string { return []string{bobLockId, "xyz"} }, err: invalidArgumentCode},
+		{name: "claim counter lock", caller: test.alice, function: "ClaimWithPreimage", argsOf: func() []string { return []string{bobLockId, preimage} },
+			want: `{"receiver":"alice","preimage":"` + preimage + `","status":"claimed"}`, event: claimEventName,
+			check: func(t *testing.T, payload []byte) {
+				event := new(LockEvent)
+				test.eventOf(t, event)
+				assertContains(t, `{"preimage":"`+preimage+`"}`, mustMarshal(t, event.Lock))
+			}},
+		{name: "claim again", caller: test.alice, function: "ClaimWithPreimage", argsOf: func() []string { return []string{bobLockId, preimage} }, err: invalidStateCode},
+		{name: "claim by anyone for receiver", caller: test.carol, function: "ClaimWithPreimage", argsOf: func() []string { return []string{aliceLockId, preimage} }, want: `{"status":"claimed"}`},
+		{name: "swapped", caller: test.alice, function: "BalanceOf", args: []string{"EUR", "user_", "alice"}, want: `{"balance":"20"}`},
+		{name: "swapped back", caller: test.alice, function: "BalanceOf", args: []string{"SJ", "user_", "bob"}, want: `{"balance":"30"}`},
+		{name: "refund claimed", caller: test.alice, function: "RefundExpired", argsOf: aliceLock, err: invalidStateCode},
+
+		{name: "lock to refund", caller: test.alice, function: "LockWithHash", args: []string{"", "bob", "10", hashlock, "2020-01-01T01:00:00Z"}, check: saveId(&aliceLockId)},
+		{name: "refund before timeout", caller: test.carol, function: "RefundExpired", argsOf: aliceLock, err: invalidStateCode},
+		{name: "expire", caller: test.carol, function: "GetLock", argsOf: aliceLock, want: `{"status":"locked"}`,
+			check: func(t *testing.T, payload []byte) { test.network.advance(2 * time.Hour) }},
+		{name: "claim expired", caller: test.bob, function: "ClaimWithPreimage", argsOf: func() []string { return []string{aliceLockId, preimage} }, err: invalidStateCode},
+		{name: "refund by anyone", caller: test.carol, function: "RefundExpired", argsOf: aliceLock, want: `{"status":"refunded"}`, event: lockRefundEventName},
+		{name: "refunded", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"70"}`},
+		{name: "unknown lock", caller: test.alice, function: "GetLock", args: []string{"nope"}, err: notFoundCode},
+	})
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Spending limits of user_ accounts, set per currency. The default limits
+// stored under defaultLimits~<currency> apply to every user without own
+// limits stored under limits~<currency>~<user id>. Outgoing transfers of the
+// last week are kept under outflow~<currency>~<user id>~<tx timestamp>~<tx id>
+// to compute the rolling daily and weekly outflow.
+var defaultLimitsKey = "defaultLimits"
+var limitsPrefix = "limits"
+var outflowPrefix = "outflow"
+
+var dayWindow = 24 * time.Hour
+var weekWindow = 7 * dayWindow
+
+// Limits are decimal amounts, empty means unlimited.
+type Limits struct {
+	MaxTransfer   string `json:"maxTransfer"`
+	DailyCap      string `json:"dailyCap"`
+	WeeklyCap     string `json:"weeklyCap"`
+	MaxBatchTotal string `json:"maxBatchTotal"`
+}
+
+type AccountLimits struct {
+	AccountId     string  `json:"accountId"`
+	Limits        *Limits `json:"limits"`
+	Custom        bool    `json:"custom"`
+	DailyOutflow  string  `json:"dailyOutflow"`
+	WeeklyOutflow string  `json:"weeklyOutflow"`
+}
+
+func (t *CoinChain) SetDefaultLimits(ctx contractapi.TransactionContextInterface, currency string, limitsJson string) (*Limits, error) {
+
+	fmt.Println("default limits of " + currency + " json: " + limitsJson)
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(defaultLimitsKey, []string{currencyInfo.Symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	return setLimits(ctx, currencyInfo, key, limitsJson)
+}
+
+func (t *CoinChain) SetAccountLimits(ctx contractapi.TransactionContextInterface, currency string, accountId string, limitsJson string) (*Limits, error) {
+
+	fmt.Println("limits of " + accountId + " in " + currency + " json: " + limitsJson)
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(limitsPrefix, []string{currencyInfo.Symbol, accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	return setLimits(ctx, currencyInfo, key, limitsJson)
+}
+
+// RemoveAccountLimits makes the default limits apply to the account again.
+func (t *CoinChain) RemoveAccountLimits(ctx contractapi.TransactionContextInterface, currency string, accountId string) error {
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(limitsPrefix, []string{currencyInfo.Symbol, accountId})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// LimitsOf returns the limits applied to the user_ account and its outflow
+// in the last day and week. Users get their own limits, auditors those of
+// any user.
+func (t *CoinChain) LimitsOf(ctx contractapi.TransactionContextInterface, currency string, accountId string) (*AccountLimits, error) {
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountId != currentUserId {
+		_, err = checkRole(ctx, auditorRole)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, custom, err := getLimits(ctx, currencyInfo.Symbol, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	daily, weekly, err := getOutflow(ctx, currencyInfo.Symbol, accountId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountLimits{
+		AccountId:     accountId,
+		Limits:        limits,
+		Custom:        custom,
+		DailyOutflow:  formatAmount(daily, currencyInfo.Decimals),
+		WeeklyOutflow: formatAmount(weekly, currencyInfo.Decimals),
+	}, nil
+}
+
+// checkLimits enforces the limits of a user_ sender for the amounts of one
+// transaction and records the outflow. Every transaction that takes coins out
+// of a user_ account calls it, except Burn, which destroys them. Amounts
+// include the fee the sender pays. The minter of the currency is not limited.
+// Calls for the same sender within a transaction add up, as scheduled
+// payments do.
+func checkLimits(ctx contractapi.TransactionContextInterface, sheet *balanceSheet, senderAccount string, amounts []*big.Int, batch bool) error {
+	senderType, senderId, err := splitAccount(ctx, senderAccount)
+	if err != nil {
+		return err
+	}
+
+	if senderType != userAccountType {
+		return nil
+	}
+
+	if senderId == sheet.currency.Minter {
+		return nil
+	}
+
+	limits, _, err := getLimits(ctx, sheet.currency.Symbol, senderId)
+	if err != nil {
+		return err
+	}
+
+	total := new(big.Int)
+	for _, amount := range amounts {
+		err = checkLimit(limits.MaxTransfer, amount, sheet.decimals, "transfer exceeds the maximum of %s")
+		if err != nil {
+			return err
+		}
+		total.Add(total, amount)
+	}
+
+	if batch {
+		err = checkLimit(limits.MaxBatchTotal, total, sheet.decimals, "batch total exceeds the maximum of %s")
+		if err != nil {
+			return err
+		}
+	}
+
+	// The ledger does not return outflow recorded earlier in this transaction
+	if pending, ok := sheet.outflow[senderId]; ok {
+		total.Add(total, pending)
+	}
+
+	daily, weekly, err := getOutflow(ctx, sheet.currency.Symbol, senderId, true)
+	if err != nil {
+		return err
+	}
+
+	err = checkLimit(limits.DailyCap, daily.Add(daily, total), sheet.decimals, "daily cap of %s exceeded")
+	if err != nil {
+		return err
+	}
+
+	err = checkLimit(limits.WeeklyCap, weekly.Add(weekly, total), sheet.decimals, "weekly cap of %s exceeded")
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(outflowPrefix, []string{sheet.currency.Symbol, senderId, fmt.Sprintf("%020d", txTime.UnixNano()), ctx.GetStub().GetTxID()})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, writeAmount(total))
+	if err != nil {
+		return err
+	}
+
+	sheet.outflow[senderId] = total
+	return nil
+}
+
+// returnOutflow takes coins which come back from a hold off the outflow the
+// Hold transaction recorded for the payer. Nothing is recorded for the
+// minter and entries older than a week are already pruned.
+func returnOutflow(ctx contractapi.TransactionContextInterface, hold *Hold, amount *big.Int) error {
+	created, err := time.Parse(time.RFC3339Nano, hold.Created)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(outflowPrefix, []string{hold.Currency, hold.Payer, fmt.Sprintf("%020d", created.UnixNano()), hold.Id})
+	if err != nil {
+		return err
+	}
+
+	outflowBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return err
+	}
+
+	if len(outflowBytes) == 0 {
+		return nil
+	}
+
+	outflow, err := readAmount(outflowBytes)
+	if err != nil {
+		return err
+	}
+
+	outflow.Sub(outflow, amount)
+	if outflow.Sign() <= 0 {
+		return ctx.GetStub().DelState(key)
+	}
+
+	return ctx.GetStub().PutState(key, writeAmount(outflow))
+}
+
+func checkLimit(limit string, amount *big.Int, decimals int, message string) error {
+	if len(limit) == 0 {
+		return nil
+	}
+
+	maxAmount, err := parseNonNegativeAmount(limit, decimals)
+	if err != nil {
+		return err
+	}
+
+	if amount.Cmp(maxAmount) > 0 {
+		return newError(limitExceededCode, message, limit)
+	}
+
+	return nil
+}
+
+// getOutflow sums outgoing transfers of the user in the currency in the last
+// day and week before the transaction timestamp. Older entries are deleted if
+// prune is set.
+func getOutflow(ctx contractapi.TransactionContextInterface, symbol string, userId string, prune bool) (*big.Int, *big.Int, error) {
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(outflowPrefix, []string{symbol, userId})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iterator.Close()
+
+	daily := new(big.Int)
+	weekly := new(big.Int)
+
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		nanos, err := strconv.ParseInt(attributes[2], 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		age := txTime.Sub(time.Unix(0, nanos))
+
+		if age >= weekWindow {
+			if prune {
+				err = ctx.GetStub().DelState(entry.Key)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			continue
+		}
+
+		amount, err := readAmount(entry.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		weekly.Add(weekly, amount)
+		if age < dayWindow {
+			daily.Add(daily, amount)
+		}
+	}
+
+	return daily, weekly, nil
+}
+
+// getLimits returns the limits of the user in the currency and whether they
+// are set for the user.
+func getLimits(ctx contractapi.TransactionContextInterface, symbol string, userId string) (*Limits, bool, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(limitsPrefix, []string{symbol, userId})
+	if err != nil {
+		return nil, false, err
+	}
+
+	limitsBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	custom := len(limitsBytes) != 0
+
+	if !custom {
+		defaultKey, err := ctx.GetStub().CreateCompositeKey(defaultLimitsKey, []string{symbol})
+		if err != nil {
+			return nil, false, err
+		}
+
+		limitsBytes, err = ctx.GetStub().GetState(defaultKey)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	limits := new(Limits)
+	if len(limitsBytes) == 0 {
+		return limits, false, nil
+	}
+
+	err = json.Unmarshal(limitsBytes, limits)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return limits, custom, nil
+}
+
+func setLimits(ctx contractapi.TransactionContextInterface, currency *Currency, key string, limitsJson string) (*Limits, error) {
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := new(Limits)
+	err = json.Unmarshal([]byte(limitsJson), limits)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "incorrect limits: %s", err.Error())
+	}
+
+	for _, limit := range []string{limits.MaxTransfer, limits.DailyCap, limits.WeeklyCap, limits.MaxBatchTotal} {
+		if len(limit) == 0 {
+			continue
+		}
+
+		_, err = parseNonNegativeAmount(limit, currency.Decimals)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limitsBytes, err := json.Marshal(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(key, limitsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
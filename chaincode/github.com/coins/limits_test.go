@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	test := newCoinsTest(t)
+	nextDay := func(t *testing.T, payload []byte) { test.network.advance(25 * time.Hour) }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "1000"}},
+		{name: "fund alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "500", "", "", ""}},
+		{name: "limits of others", caller: test.bob, function: "LimitsOf", args: []string{"", "alice"}, err: noPermissionsCode},
+		{name: "no limits", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"},
+			want: `{"accountId":"alice","limits":{"maxTransfer":"","dailyCap":"","weeklyCap":"","maxBatchTotal":""},"custom":false,"dailyOutflow":"0","weeklyOutflow":"0"}`},
+
+		{name: "set by user", caller: test.alice, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":"1000"}`}, err: noPermissionsCode},
+		{name: "set malformed", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":5}`}, err: invalidArgumentCode},
+		{name: "set bad amount", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":"five"}`}, err: invalidAmountCode},
+		{name: "set default", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":"50","dailyCap":"100","weeklyCap":"150","maxBatchTotal":"60"}`},
+			want: `{"maxTransfer":"50","dailyCap":"100","weeklyCap":"150","maxBatchTotal":"60"}`},
+
+		{name: "transfer over maximum", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "60", "", "", ""}, err: limitExceededCode},
+		{name: "transfer", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "50", "", "", ""}, want: `{"balance":"450"}`},
+		{name: "transfer again", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "40", "", "", ""}, want: `{"balance":"410"}`},
+		{name: "transfer over daily cap", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "20", "", "", ""}, err: limitExceededCode},
+		{name: "batch up to daily cap", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"5"},{"userId":"carol","amount":"5"}]`, ""}, want: `{"balance":"400"}`},
+		{name: "outflow", caller: test.auditor, function: "LimitsOf", args: []string{"", "alice"}, want: `{"custom":false,"dailyOutflow":"100","weeklyOutflow":"100"}`, check: nextDay},
+
+		{name: "batch over batch total", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"40"},{"userId":"carol","amount":"30"}]`, ""}, err: limitExceededCode},
+		{name: "transfer next day", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "50", "", "", ""}, want: `{"balance":"350"}`, check: nextDay},
+		{name: "transfer over weekly cap", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "10", "", "", ""}, err: limitExceededCode},
+		{name: "minter is not limited", caller: test.minter, function: "Transfer", args: []string{"", "user_", "bob", "400", "", "", ""}, want: `{"balance":"100"}`},
+
+		{name: "set account limits by user", caller: test.alice, function: "SetAccountLimits", args: []string{"", "alice", `{"weeklyCap":"1000"}`}, err: noPermissionsCode},
+		{name: "set account limits", caller: test.admin, function: "SetAccountLimits", args: []string{"", "alice", `{"weeklyCap":"1000"}`}, want: `{"maxTransfer":"","weeklyCap":"1000"}`},
+		{name: "account limits", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"limits":{"maxTransfer":"","weeklyCap":"1000"},"custom":true,"dailyOutflow":"0","weeklyOutflow":"150"}`},
+		{name: "transfer with account limits", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "100", "", "", ""}, want: `{"balance":"250"}`},
+
+		{name: "remove by user", caller: test.alice, function: "RemoveAccountLimits", args: []string{"", "alice"}, err: noPermissionsCode},
+		{name: "remove", caller: test.admin, function: "RemoveAccountLimits", args: []string{"", "alice"}},
+		{name: "default limits apply again", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "10", "", "", ""}, err: limitExceededCode},
+	})
+}
+
+func TestLimitedPaths(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "1000"}},
+		{name: "fund alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "500", "", "", ""}},
+		{name: "set default", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":"50","dailyCap":"80"}`}},
+
+		{name: "fund reserve over maximum", caller: test.alice, function: "FundReserve", args: []string{"", "60"}, err: limitExceededCode},
+		{name: "fund reserve", caller: test.alice, function: "FundReserve", args: []string{"", "50"}},
+		{name: "fund reserve over daily cap", caller: test.alice, function: "FundReserve", args: []string{"", "40"}, err: limitExceededCode},
+		{name: "outflow", caller: test.auditor, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"50"}`},
+		{name: "grant by minter is not limited", caller: test.minter, function: "CreateGrant", args: []string{"", "alice", "100", "2020-01-01T00:00:00Z", "0", "3600"}},
+	})
+}
+
+// Fees paid by the sender count toward the limits.
+func TestLimitsWithFees(t *testing.T) {
+	test := newCoinsTest(t)
+	nextDay := func(t *testing.T, payload []byte) { test.network.advance(25 * time.Hour) }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "1000"}},
+		{name: "fund alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "500", "", "", ""}},
+		{name: "set default", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"maxTransfer":"50","dailyCap":"100","maxBatchTotal":"60"}`}},
+		{name: "set fee", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"1"},"accountTypes":[],"exemptAccountTypes":[]}`}},
+
+		{name: "transfer with fee over maximum", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "50", "", "", ""}, err: limitExceededCode},
+		{name: "transfer", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "49", "", "", ""}, want: `{"balance":"450","fee":"1"}`},
+		{name: "approve", caller: test.alice, function: "Approve", args: []string{"", "user_", "bob", "100"}},
+		{name: "transfer from with fee over maximum", caller: test.bob, function: "TransferFrom", args: []string{"", "user_", "alice", "user_", "carol", "50"}, err: limitExceededCode},
+		{name: "transfer from", caller: test.bob, function: "TransferFrom", args: []string{"", "user_", "alice", "user_", "carol", "49"}, want: `{"balance":"400"}`},
+		{name: "outflow", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"100"}`, check: nextDay},
+
+		{name: "batch with fees over batch total", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"29"},{"userId":"carol","amount":"30"}]`, ""}, err: limitExceededCode},
+		{name: "batch", caller: test.alice, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"29"},{"userId":"carol","amount":"29"}]`, ""}, want: `{"balance":"340"}`},
+	})
+}
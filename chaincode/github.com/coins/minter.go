@@ -0,0 +1,483 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+var pendingMinterKey = "pendingMinter"
+var mintPolicyKey = "mintPolicy"
+var mintProposalPrefix = "mintProposal"
+
+// MintPolicy enables multi-signature minting of all currencies: when
+// Threshold is above zero, Mint is disabled and minted coins must be approved
+// by Threshold distinct Approvers within ExpirySeconds after ProposeMint. An
+// active policy is changed the same way, by ProposeMintPolicy.
+type MintPolicy struct {
+	Threshold     int      `json:"threshold"`
+	Approvers     []string `json:"approvers"`
+	ExpirySeconds int64    `json:"expirySeconds"`
+}
+
+// MintProposal is a pending mint, or a pending change of the mint policy if
+// Policy is set.
+type MintProposal struct {
+	Id        string      `json:"id"`
+	Currency  string      `json:"currency"`
+	Proposer  string      `json:"proposer"`
+	Account   string      `json:"account"`
+	Amount    string      `json:"amount"`
+	Policy    *MintPolicy `json:"policy,omitempty" metadata:"policy,optional"`
+	Approvals []string    `json:"approvals"`
+	Expires   string      `json:"expires"`
+	Executed  bool        `json:"executed"`
+}
+
+// TransferMinter proposes a new minter. The handover is completed when the
+// new minter calls AcceptMinter.
+func (t *CoinChain) TransferMinter(ctx contractapi.TransactionContextInterface, newMinter string) error {
+
+	fmt.Println("new minter " + newMinter)
+
+	if len(newMinter) == 0 {
+		return newError(invalidArgumentCode, "incorrect minter ID")
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return err
+	}
+
+	minterBytes, err := ctx.GetStub().GetState(minterKey)
+	if err != nil {
+		return err
+	}
+
+	if currentUserId != string(minterBytes) {
+		return newError(noPermissionsCode, "no permissions")
+	}
+
+	return ctx.GetStub().PutState(pendingMinterKey, []byte(newMinter))
+}
+
+func (t *CoinChain) AcceptMinter(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	pendingMinterBytes, err := ctx.GetStub().GetState(pendingMinterKey)
+	if err != nil {
+		return "", err
+	}
+
+	if len(pendingMinterBytes) == 0 || currentUserId != string(pendingMinterBytes) {
+		return "", newError(noPermissionsCode, "no pending minter transfer to this user")
+	}
+
+	err = ctx.GetStub().PutState(minterKey, pendingMinterBytes)
+	if err != nil {
+		return "", err
+	}
+
+	currency, err := getCurrency(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	currency.Minter = currentUserId
+
+	err = saveCurrency(ctx, currency)
+	if err != nil {
+		return "", err
+	}
+
+	err = ctx.GetStub().DelState(pendingMinterKey)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("minter " + currentUserId)
+
+	return currentUserId, nil
+}
+
+// SetMintPolicy enables multi-signature minting. Once it is enabled the
+// policy can only be changed or turned off (threshold 0) with the approval of
+// the approvers, see ProposeMintPolicy.
+func (t *CoinChain) SetMintPolicy(ctx contractapi.TransactionContextInterface, threshold int, approvers []string, expirySeconds int64) (*MintPolicy, error) {
+
+	fmt.Println("threshold ", threshold)
+	fmt.Println("approvers ", approvers)
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := newMintPolicy(threshold, approvers, expirySeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	currentPolicy, err := getMintPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentPolicy.Threshold > 0 {
+		return nil, newError(invalidStateCode, "changing the mint policy requires approvals, use ProposeMintPolicy")
+	}
+
+	err = saveMintPolicy(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// ProposeMintPolicy creates a pending change of the active mint policy which
+// is applied by the ApproveMint call that reaches the current threshold.
+func (t *CoinChain) ProposeMintPolicy(ctx contractapi.TransactionContextInterface, threshold int, approvers []string, expirySeconds int64) (*MintProposal, error) {
+
+	fmt.Println("propose threshold ", threshold)
+
+	currentUserId, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := newMintPolicy(threshold, approvers, expirySeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	currentPolicy, err := getMintPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentPolicy.Threshold == 0 {
+		return nil, newError(invalidStateCode, "multi-signature minting is disabled, use SetMintPolicy")
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := &MintProposal{
+		Id:        ctx.GetStub().GetTxID(),
+		Proposer:  currentUserId,
+		Policy:    policy,
+		Approvals: []string{},
+		Expires:   txTime.Add(time.Duration(currentPolicy.ExpirySeconds) * time.Second).Format(time.RFC3339Nano),
+	}
+
+	err = saveMintProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+func (t *CoinChain) GetMintPolicy(ctx contractapi.TransactionContextInterface) (*MintPolicy, error) {
+	return getMintPolicy(ctx)
+}
+
+// ProposeMint creates a pending mint of the currency to the proposer's
+// account which is executed by the ApproveMint call that reaches the policy
+// threshold.
+func (t *CoinChain) ProposeMint(ctx contractapi.TransactionContextInterface, currency string, amount string) (*MintProposal, error) {
+
+	fmt.Println("propose mint " + currency + " amount: " + amount)
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := checkMinter(ctx, currencyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, currencyInfo.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNotPaused(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := getMintPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.Threshold == 0 {
+		return nil, newError(invalidStateCode, "multi-signature minting is disabled, use Mint")
+	}
+
+	currentUserAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := &MintProposal{
+		Id:        ctx.GetStub().GetTxID(),
+		Currency:  currencyInfo.Symbol,
+		Proposer:  currentUserId,
+		Account:   currentUserAccount,
+		Amount:    formatAmount(value, currencyInfo.Decimals),
+		Approvals: []string{},
+		Expires:   txTime.Add(time.Duration(policy.ExpirySeconds) * time.Second).Format(time.RFC3339Nano),
+	}
+
+	err = saveMintProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+func (t *CoinChain) ApproveMint(ctx contractapi.TransactionContextInterface, proposalId string) (*MintProposal, error) {
+
+	fmt.Println("approve mint " + proposalId)
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := getMintPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	isApprover := false
+	for _, approver := range policy.Approvers {
+		if approver == currentUserId {
+			isApprover = true
+		}
+	}
+
+	if !isApprover {
+		return nil, newError(noPermissionsCode, "no permissions")
+	}
+
+	proposal, err := getMintProposal(ctx, proposalId)
+	if err != nil {
+		return nil, err
+	}
+
+	if proposal.Executed {
+		return nil, newError(invalidStateCode, "mint is already executed")
+	}
+
+	expired, err := isExpired(ctx, proposal.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, newError(invalidStateCode, "mint proposal is expired")
+	}
+
+	for _, approval := range proposal.Approvals {
+		if approval == currentUserId {
+			return nil, newError(invalidStateCode, "mint is already approved by this user")
+		}
+	}
+
+	proposal.Approvals = append(proposal.Approvals, currentUserId)
+
+	if policy.Threshold > 0 && len(proposal.Approvals) >= policy.Threshold && proposal.Policy != nil {
+		err = saveMintPolicy(ctx, proposal.Policy)
+		if err != nil {
+			return nil, err
+		}
+
+		proposal.Executed = true
+	} else if policy.Threshold > 0 && len(proposal.Approvals) >= policy.Threshold {
+		sheet, err := newBalanceSheet(ctx, proposal.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parseAmount(proposal.Amount, sheet.decimals)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.mint(proposal.Account, value)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.save()
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.emit(mintEventName)
+		if err != nil {
+			return nil, err
+		}
+
+		proposal.Executed = true
+	}
+
+	err = saveMintProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// CancelMint deletes a pending mint. The proposer can cancel own proposals,
+// anyone can clean up expired ones.
+func (t *CoinChain) CancelMint(ctx contractapi.TransactionContextInterface, proposalId string) error {
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return err
+	}
+
+	proposal, err := getMintProposal(ctx, proposalId)
+	if err != nil {
+		return err
+	}
+
+	if proposal.Executed {
+		return newError(invalidStateCode, "mint is already executed")
+	}
+
+	expired, err := isExpired(ctx, proposal.Expires)
+	if err != nil {
+		return err
+	}
+
+	if !expired && proposal.Proposer != currentUserId {
+		return newError(noPermissionsCode, "no permissions")
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(mintProposalPrefix, []string{proposalId})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+func (t *CoinChain) GetMintProposal(ctx contractapi.TransactionContextInterface, proposalId string) (*MintProposal, error) {
+	return getMintProposal(ctx, proposalId)
+}
+
+func getMintPolicy(ctx contractapi.TransactionContextInterface) (*MintPolicy, error) {
+
+	policyBytes, err := ctx.GetStub().GetState(mintPolicyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(MintPolicy)
+	policy.Approvers = []string{}
+
+	if len(policyBytes) == 0 {
+		return policy, nil
+	}
+
+	err = json.Unmarshal(policyBytes, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func newMintPolicy(threshold int, approvers []string, expirySeconds int64) (*MintPolicy, error) {
+
+	if threshold < 0 || threshold > len(approvers) {
+		return nil, newError(invalidArgumentCode, "threshold must be between 0 and %d", len(approvers))
+	}
+
+	if threshold > 0 && expirySeconds <= 0 {
+		return nil, newError(invalidArgumentCode, "incorrect expiry")
+	}
+
+	distinct := make(map[string]bool)
+	for _, approver := range approvers {
+		if len(approver) == 0 || distinct[approver] {
+			return nil, newError(invalidArgumentCode, "incorrect approver %q", approver)
+		}
+		distinct[approver] = true
+	}
+
+	return &MintPolicy{Threshold: threshold, Approvers: approvers, ExpirySeconds: expirySeconds}, nil
+}
+
+func saveMintPolicy(ctx contractapi.TransactionContextInterface, policy *MintPolicy) error {
+
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(mintPolicyKey, policyBytes)
+}
+
+func getMintProposal(ctx contractapi.TransactionContextInterface, proposalId string) (*MintProposal, error) {
+
+	key, err := ctx.GetStub().CreateCompositeKey(mintProposalPrefix, []string{proposalId})
+	if err != nil {
+		return nil, err
+	}
+
+	proposalBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(proposalBytes) == 0 {
+		return nil, newError(notFoundCode, "mint proposal does not exist")
+	}
+
+	proposal := new(MintProposal)
+	err = json.Unmarshal(proposalBytes, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+func saveMintProposal(ctx contractapi.TransactionContextInterface, proposal *MintProposal) error {
+
+	key, err := ctx.GetStub().CreateCompositeKey(mintProposalPrefix, []string{proposal.Id})
+	if err != nil {
+		return err
+	}
+
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, proposalBytes)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinterHandover(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "transfer by user", caller: test.alice, function: "TransferMinter", args: []string{"alice"}, err: noPermissionsCode},
+		{name: "transfer to nobody", caller: test.minter, function: "TransferMinter", args: []string{""}, err: invalidArgumentCode},
+		{name: "transfer", caller: test.minter, function: "TransferMinter", args: []string{"alice"}},
+		{name: "accept by other user", caller: test.bob, function: "AcceptMinter", err: noPermissionsCode},
+		{name: "accept", caller: test.alice, function: "AcceptMinter", want: "alice"},
+		{name: "accept again", caller: test.alice, function: "AcceptMinter", err: noPermissionsCode},
+		{name: "new minter", caller: test.bob, function: "TokenInfo", args: []string{""}, want: `{"minter":"alice"}`},
+		{name: "mint by new minter", caller: test.alice, function: "Mint", args: []string{"", "10"}, want: `{"balance":"10"}`},
+		{name: "mint by old minter", caller: test.minter, function: "Mint", args: []string{"", "10"}, err: noPermissionsCode},
+	})
+}
+
+func TestMultiSignatureMint(t *testing.T) {
+	test := newCoinsTest(t)
+	var proposalId string
+	proposal := func() []string { return []string{proposalId} }
+
+	test.run(t, []txCase{
+		{name: "no policy", caller: test.alice, function: "GetMintPolicy", want: `{"threshold":0,"approvers":[],"expirySeconds":0}`},
+		{name: "propose without policy", caller: test.minter, function: "ProposeMint", args: []string{"", "50"}, err: invalidStateCode},
+		{name: "set by user", caller: test.bob, function: "SetMintPolicy", args: []string{"2", `["bob","carol"]`, "3600"}, err: noPermissionsCode},
+		{name: "set threshold above approvers", caller: test.admin, function: "SetMintPolicy", args: []string{"3", `["bob","carol"]`, "3600"}, err: invalidArgumentCode},
+		{name: "set duplicate approvers", caller: test.admin, function: "SetMintPolicy", args: []string{"2", `["bob","bob"]`, "3600"}, err: invalidArgumentCode},
+		{name: "set without expiry", caller: test.admin, function: "SetMintPolicy", args: []string{"2", `["bob","carol"]`, "0"}, err: invalidArgumentCode},
+		{name: "set", caller: test.admin, function: "SetMintPolicy", args: []string{"2", `["bob","carol","admin"]`, "3600"}, want: `{"threshold":2,"approvers":["bob","carol","admin"],"expirySeconds":3600}`},
+		{name: "policy", caller: test.alice, function: "GetMintPolicy", want: `{"threshold":2,"approvers":["bob","carol","admin"],"expirySeconds":3600}`},
+		{name: "mint with policy", caller: test.minter, function: "Mint", args: []string{"", "50"}, err: invalidStateCode},
+		{name: "create currency", caller: test.admin, function: "CreateCurrency", args: []string{"EUR", "Euro", "carol", "2"}},
+		{name: "mint other currency with policy", caller: test.carol, function: "Mint", args: []string{"EUR", "50"}, err: invalidStateCode},
+		{name: "propose other currency by other minter", caller: test.minter, function: "ProposeMint", args: []string{"EUR", "50"}, err: noPermissionsCode},
+		{name: "propose other currency", caller: test.carol, function: "ProposeMint", args: []string{"EUR", "50"},
+			want: `{"currency":"EUR","proposer":"carol","amount":"50","approvals":[],"executed":false}`},
+		{name: "change policy by minter", caller: test.minter, function: "SetMintPolicy", args: []string{"0", `[]`, "0"}, err: invalidStateCode},
+		{name: "change policy by admin", caller: test.admin, function: "SetMintPolicy", args: []string{"1", `["admin"]`, "3600"}, err: invalidStateCode},
+
+		{name: "propose by user", caller: test.bob, function: "ProposeMint", args: []string{"", "50"}, err: noPermissionsCode},
+		{name: "propose", caller: test.minter, function: "ProposeMint", args: []string{"", "50"},
+			want: `{"currency":"SJ","proposer":"sj_coin","amount":"50","approvals":[],"expires":"2020-01-01T01:00:00Z","executed":false}`, check: saveId(&proposalId)},
+		{name: "approve by non-approver", caller: test.alice, function: "ApproveMint", argsOf: proposal, err: noPermissionsCode},
+		{name: "approve", caller: test.bob, function: "ApproveMint", argsOf: proposal, want: `{"approvals":["bob"],"executed":false}`},
+		{name: "approve twice", caller: test.bob, function: "ApproveMint", argsOf: proposal, err: invalidStateCode},
+		{name: "approve to threshold", caller: test.carol, function: "ApproveMint", argsOf: proposal, want: `{"approvals":["bob","carol"],"executed":true}`, event: mintEventName},
+		{name: "approve executed", caller: test.admin, function: "ApproveMint", argsOf: proposal, err: invalidStateCode},
+		{name: "cancel executed", caller: test.minter, function: "CancelMint", argsOf: proposal, err: invalidStateCode},
+		{name: "proposal", caller: test.alice, function: "GetMintProposal", argsOf: proposal, want: `{"executed":true}`},
+		{name: "minted", caller: test.alice, function: "TotalSupply", args: []string{""}, want: "50"},
+		{name: "unknown proposal", caller: test.alice, function: "GetMintProposal", args: []string{"nope"}, err: notFoundCode},
+
+		{name: "propose again", caller: test.minter, function: "ProposeMint", args: []string{"", "5"}, check: saveId(&proposalId)},
+		{name: "cancel by other user", caller: test.bob, function: "CancelMint", argsOf: proposal, err: noPermissionsCode},
+		{name: "expire", caller: test.alice, function: "GetMintProposal", argsOf: proposal, check: func(t *testing.T, payload []byte) { test.network.advance(2 * time.Hour) }},
+		{name: "approve expired", caller: test.bob, function: "ApproveMint", argsOf: proposal, err: invalidStateCode},
+		{name: "cancel expired by anyone", caller: test.bob, function: "CancelMint", argsOf: proposal},
+		{name: "cancelled proposal", caller: test.alice, function: "GetMintProposal", argsOf: proposal, err: notFoundCode},
+
+		{name: "propose once more", caller: test.minter, function: "ProposeMint", args: []string{"", "1"}, check: saveId(&proposalId)},
+		{name: "cancel by proposer", caller: test.minter, function: "CancelMint", argsOf: proposal},
+
+		{name: "propose policy by user", caller: test.bob, function: "ProposeMintPolicy", args: []string{"0", `[]`, "0"}, err: noPermissionsCode},
+		{name: "propose bad policy", caller: test.minter, function: "ProposeMintPolicy", args: []string{"2", `["bob"]`, "3600"}, err: invalidArgumentCode},
+		{name: "propose turning off", caller: test.minter, function: "ProposeMintPolicy", args: []string{"0", `[]`, "0"},
+			want: `{"proposer":"sj_coin","policy":{"threshold":0,"approvers":[],"expirySeconds":0},"approvals":[],"executed":false}`, check: saveId(&proposalId)},
+		{name: "approve turning off", caller: test.bob, function: "ApproveMint", argsOf: proposal, want: `{"approvals":["bob"],"executed":false}`},
+		{name: "still on", caller: test.minter, function: "Mint", args: []string{"", "1"}, err: invalidStateCode},
+		{name: "turn off", caller: test.admin, function: "ApproveMint", argsOf: proposal, want: `{"approvals":["bob","admin"],"executed":true}`},
+		{name: "policy turned off", caller: test.alice, function: "GetMintPolicy", want: `{"threshold":0}`},
+		{name: "propose policy without policy", caller: test.admin, function: "ProposeMintPolicy", args: []string{"1", `["admin"]`, "3600"}, err: invalidStateCode},
+		{name: "mint without policy", caller: test.minter, function: "Mint", args: []string{"", "1"}, want: `{"balance":"51"}`},
+	})
+}
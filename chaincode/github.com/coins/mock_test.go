@@ -0,0 +1,833 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/attrmgr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// mockNetwork is an in-memory channel for unit tests. It keeps the committed
+// state and history of every registered chaincode and runs transactions
+// through their shim.Chaincode implementation like a peer does: reads and
+// range queries see the committed state only, writes are committed when the
+// transaction succeeds, and InvokeChaincode calls registered chaincodes
+// within the same transaction.
+type mockNetwork struct {
+	chaincodes map[string]shim.Chaincode
+	states     map[string]map[string][]byte
+	history    map[string]map[string][]*queryresult.KeyModification
+	now        time.Time
+	txCount    int
+	lastEvent  *peer.ChaincodeEvent
+}
+
+var mockChannel = "mychannel"
+
+func newMockNetwork() *mockNetwork {
+	return &mockNetwork{
+		chaincodes: make(map[string]shim.Chaincode),
+		states:     make(map[string]map[string][]byte),
+		history:    make(map[string]map[string][]*queryresult.KeyModification),
+		now:        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (n *mockNetwork) register(name string, chaincode shim.Chaincode) {
+	n.chaincodes[name] = chaincode
+	n.states[name] = make(map[string][]byte)
+	n.history[name] = make(map[string][]*queryresult.KeyModification)
+}
+
+// advance moves the clock used for transaction timestamps.
+func (n *mockNetwork) advance(duration time.Duration) {
+	n.now = n.now.Add(duration)
+}
+
+// invoke submits a transaction of the identity. Writes and the event are
+// committed only if the chaincode returns OK.
+func (n *mockNetwork) invoke(identity *mockIdentity, chaincodeName string, function string, args ...string) peer.Response {
+	n.txCount++
+
+	tx := &mockTx{
+		network:   n,
+		id:        fmt.Sprintf("tx%06d", n.txCount),
+		timestamp: &timestamp.Timestamp{Seconds: n.now.Unix(), Nanos: int32(n.now.Nanosecond())},
+		creator:   identity.creator,
+		writes:    make(map[string]map[string]*mockWrite),
+	}
+
+	response := tx.invoke(chaincodeName, append([]string{function}, args...))
+	if response.Status != shim.OK {
+		return response
+	}
+
+	tx.commit()
+	n.lastEvent = tx.event
+
+	return response
+}
+
+// state returns the committed value of the key.
+func (n *mockNetwork) state(chaincodeName string, key string) []byte {
+	return n.states[chaincodeName][key]
+}
+
+// setState writes a value outside of any transaction, e.g. legacy state.
+func (n *mockNetwork) setState(chaincodeName string, key string, value []byte) {
+	n.states[chaincodeName][key] = value
+}
+
+type mockWrite struct {
+	value  []byte
+	delete bool
+}
+
+type mockTx struct {
+	network   *mockNetwork
+	id        string
+	timestamp *timestamp.Timestamp
+	creator   []byte
+	writes    map[string]map[string]*mockWrite
+	event     *peer.ChaincodeEvent
+}
+
+func (tx *mockTx) invoke(chaincodeName string, args []string) peer.Response {
+	chaincode, ok := tx.network.chaincodes[chaincodeName]
+	if !ok {
+		return shim.Error("chaincode " + chaincodeName + " is not registered")
+	}
+
+	if tx.writes[chaincodeName] == nil {
+		tx.writes[chaincodeName] = make(map[string]*mockWrite)
+	}
+
+	byteArgs := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		byteArgs = append(byteArgs, []byte(arg))
+	}
+
+	return chaincode.Invoke(&mockStub{tx: tx, chaincode: chaincodeName, args: byteArgs})
+}
+
+func (tx *mockTx) commit() {
+	chaincodeNames := make([]string, 0, len(tx.writes))
+	for chaincodeName := range tx.writes {
+		chaincodeNames = append(chaincodeNames, chaincodeName)
+	}
+	sort.Strings(chaincodeNames)
+
+	for _, chaincodeName := range chaincodeNames {
+		keys := make([]string, 0, len(tx.writes[chaincodeName]))
+		for key := range tx.writes[chaincodeName] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		state := tx.network.states[chaincodeName]
+		history := tx.network.history[chaincodeName]
+
+		for _, key := range keys {
+			write := tx.writes[chaincodeName][key]
+			if write.delete {
+				delete(state, key)
+			} else {
+				state[key] = write.value
+			}
+
+			history[key] = append(history[key], &queryresult.KeyModification{
+				TxId:      tx.id,
+				Value:     write.value,
+				Timestamp: tx.timestamp,
+				IsDelete:  write.delete,
+			})
+		}
+	}
+}
+
+// mockStub implements shim.ChaincodeStubInterface for one chaincode within a
+// mock transaction. Private data and rich queries are not supported.
+type mockStub struct {
+	tx        *mockTx
+	chaincode string
+	args      [][]byte
+}
+
+func (s *mockStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *mockStub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *mockStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *mockStub) GetArgsSlice() ([]byte, error) {
+	var slice []byte
+	for _, arg := range s.args {
+		slice = append(slice, arg...)
+	}
+	return slice, nil
+}
+
+func (s *mockStub) GetTxID() string {
+	return s.tx.id
+}
+
+func (s *mockStub) GetChannelID() string {
+	return mockChannel
+}
+
+func (s *mockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	if len(channel) != 0 && channel != mockChannel {
+		return shim.Error("channel " + channel + " is not supported")
+	}
+
+	stringArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		stringArgs = append(stringArgs, string(arg))
+	}
+
+	return s.tx.invoke(chaincodeName, stringArgs)
+}
+
+func (s *mockStub) GetState(key string) ([]byte, error) {
+	return s.tx.network.states[s.chaincode][key], nil
+}
+
+func (s *mockStub) PutState(key string, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("key must not be an empty string")
+	}
+	s.tx.writes[s.chaincode][key] = &mockWrite{value: value}
+	return nil
+}
+
+func (s *mockStub) DelState(key string) error {
+	s.tx.writes[s.chaincode][key] = &mockWrite{delete: true}
+	return nil
+}
+
+func (s *mockStub) SetStateValidationParameter(key string, ep []byte) error {
+	return nil
+}
+
+func (s *mockStub) GetStateValidationParameter(key string) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *mockStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	iterator, _, err := s.queryRange(startKey, endKey, 0, "")
+	return iterator, err
+}
+
+func (s *mockStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return s.queryRange(startKey, endKey, pageSize, bookmark)
+}
+
+func (s *mockStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	iterator, _, err := s.GetStateByPartialCompositeKeyWithPagination(objectType, keys, 0, "")
+	return iterator, err
+}
+
+func (s *mockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.queryRange(prefix, prefix+string(utf8.MaxRune), pageSize, bookmark)
+}
+
+// queryRange returns committed keys in [startKey, endKey), an empty endKey
+// means no upper bound. A page starts at the bookmark key and the returned
+// bookmark is the first key of the next page.
+func (s *mockStub) queryRange(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if len(bookmark) != 0 {
+		if bookmark < startKey || (len(endKey) != 0 && bookmark >= endKey) {
+			return nil, nil, fmt.Errorf("bookmark %q is out of range", bookmark)
+		}
+		startKey = bookmark
+	}
+
+	state := s.tx.network.states[s.chaincode]
+
+	keys := []string{}
+	for key := range state {
+		if key >= startKey && (len(endKey) == 0 || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	metadata := new(peer.QueryResponseMetadata)
+	if pageSize > 0 && len(keys) > int(pageSize) {
+		metadata.Bookmark = keys[pageSize]
+		keys = keys[:pageSize]
+	}
+	metadata.FetchedRecordsCount = int32(len(keys))
+
+	iterator := new(mockStateIterator)
+	for _, key := range keys {
+		iterator.entries = append(iterator.entries, &queryresult.KV{Namespace: s.chaincode, Key: key, Value: state[key]})
+	}
+
+	return iterator, metadata, nil
+}
+
+func (s *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *mockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, "\x00") || !strings.HasSuffix(compositeKey, "\x00") {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+
+	components := strings.Split(compositeKey[1:len(compositeKey)-1], "\x00")
+	return components[0], components[1:], nil
+}
+
+func (s *mockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("rich queries are not supported by the mock stub")
+}
+
+func (s *mockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return nil, nil, errors.New("rich queries are not supported by the mock stub")
+}
+
+func (s *mockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	// Fabric returns the newest modification first
+	modifications := s.tx.network.history[s.chaincode][key]
+
+	iterator := new(mockHistoryIterator)
+	for i := len(modifications) - 1; i >= 0; i-- {
+		iterator.entries = append(iterator.entries, modifications[i])
+	}
+
+	return iterator, nil
+}
+
+func (s *mockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return nil, errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	return nil, errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) PutPrivateData(collection string, key string, value []byte) error {
+	return errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) DelPrivateData(collection string, key string) error {
+	return errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+	return errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) GetPrivateDataValidationParameter(collection string, key string) ([]byte, error) {
+	return nil, errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) GetPrivateDataQueryResult(collection string, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("private data is not supported by the mock stub")
+}
+
+func (s *mockStub) GetCreator() ([]byte, error) {
+	return s.tx.creator, nil
+}
+
+func (s *mockStub) GetTransient() (map[string][]byte, error) {
+	return map[string][]byte{}, nil
+}
+
+func (s *mockStub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *mockStub) GetDecorations() map[string][]byte {
+	return map[string][]byte{}
+}
+
+func (s *mockStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	return nil, errors.New("signed proposals are not supported by the mock stub")
+}
+
+func (s *mockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return s.tx.timestamp, nil
+}
+
+func (s *mockStub) SetEvent(name string, payload []byte) error {
+	if len(name) == 0 {
+		return errors.New("event name can not be empty string")
+	}
+	s.tx.event = &peer.ChaincodeEvent{ChaincodeId: s.chaincode, TxId: s.tx.id, EventName: name, Payload: payload}
+	return nil
+}
+
+type mockStateIterator struct {
+	entries []*queryresult.KV
+}
+
+func (i *mockStateIterator) HasNext() bool {
+	return len(i.entries) != 0
+}
+
+func (i *mockStateIterator) Next() (*queryresult.KV, error) {
+	if len(i.entries) == 0 {
+		return nil, errors.New("no more entries")
+	}
+	entry := i.entries[0]
+	i.entries = i.entries[1:]
+	return entry, nil
+}
+
+func (i *mockStateIterator) Close() error {
+	return nil
+}
+
+type mockHistoryIterator struct {
+	entries []*queryresult.KeyModification
+}
+
+func (i *mockHistoryIterator) HasNext() bool {
+	return len(i.entries) != 0
+}
+
+func (i *mockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if len(i.entries) == 0 {
+		return nil, errors.New("no more entries")
+	}
+	entry := i.entries[0]
+	i.entries = i.entries[1:]
+	return entry, nil
+}
+
+func (i *mockHistoryIterator) Close() error {
+	return nil
+}
+
+// mockIdentity is a client with a generated self-signed certificate carrying
+// Fabric CA enrollment attributes.
+type mockIdentity struct {
+	mspId   string
+	name    string
+	creator []byte
+}
+
+func newMockIdentity(t *testing.T, mspId string, commonName string, attributes map[string]string) *mockIdentity {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspId}},
+		NotBefore:    time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	if len(attributes) != 0 {
+		err = new(attrmgr.Mgr).AddAttributesToCert(&attrmgr.Attributes{Attrs: attributes}, template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// CreateCertificate only writes ExtraExtensions
+		template.ExtraExtensions = template.Extensions
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &mockIdentity{mspId: mspId, name: commonName, creator: creator}
+}
+
+// mockUsersChaincode stands in for the users chaincode: getUserDataById
+// succeeds for the known user IDs only.
+type mockUsersChaincode struct {
+	users map[string]bool
+}
+
+func (c *mockUsersChaincode) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (c *mockUsersChaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function != getUserFunction || len(args) != 1 {
+		return shim.Error("unknown function " + function)
+	}
+
+	if !c.users[args[0]] {
+		return shim.Error("user " + args[0] + " not found")
+	}
+
+	return shim.Success([]byte(`{"id":"` + args[0] + `"}`))
+}
+
+// mockEchoChaincode returns its arguments separated by spaces.
+type mockEchoChaincode struct{}
+
+func (c *mockEchoChaincode) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (c *mockEchoChaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success([]byte(strings.Join(stub.GetStringArgs(), " ")))
+}
+
+var coinsChaincode = "coins"
+var usersChaincode = "users"
+var echoChaincode = "echo"
+
+var homeMsp = "Org1MSP"
+var partnerMsp = "Org2MSP"
+
+// coinsTest is a network with the coins chaincode initialized with the
+// default currency SJ (2 decimals) minted by the minter, and a users
+// chaincode which knows alice and bob.
+type coinsTest struct {
+	network *mockNetwork
+	minter  *mockIdentity
+	admin   *mockIdentity
+	auditor *mockIdentity
+	alice   *mockIdentity
+	bob     *mockIdentity
+	carol   *mockIdentity
+	partner *mockIdentity
+}
+
+func newCoinsTest(t *testing.T) *coinsTest {
+	t.Helper()
+
+	chaincode, err := contractapi.NewChaincode(new(CoinChain))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := newMockNetwork()
+	network.register(coinsChaincode, chaincode)
+	network.register(usersChaincode, &mockUsersChaincode{users: map[string]bool{"alice": true, "bob": true}})
+
+	test := &coinsTest{
+		network: network,
+		minter:  newMockIdentity(t, homeMsp, "sj_coin", nil),
+		admin:   newMockIdentity(t, homeMsp, "admin", map[string]string{roleAttribute: adminRole}),
+		auditor: newMockIdentity(t, homeMsp, "auditor", map[string]string{roleAttribute: auditorRole}),
+		alice:   newMockIdentity(t, homeMsp, "alice", nil),
+		bob:     newMockIdentity(t, homeMsp, "enrollment-bob", map[string]string{userIdAttribute: "bob"}),
+		carol:   newMockIdentity(t, homeMsp, "carol", nil),
+		partner: newMockIdentity(t, partnerMsp, "alice", nil),
+	}
+
+	response := network.invoke(test.minter, coinsChaincode, "InitLedger", "sj_coin", "SJ", "2")
+	if response.Status != shim.OK {
+		t.Fatalf("InitLedger failed: %s", response.Message)
+	}
+
+	return test
+}
+
+// txCase is one transaction of a test scenario. The transaction must fail
+// with an error starting with err, or succeed and return a payload that
+// contains all fields of want (a JSON value or a plain string).
+type txCase struct {
+	name      string
+	caller    *mockIdentity
+	chaincode string // coins if empty
+	function  string
+	args      []string
+	argsOf    func() []string // args depending on earlier cases, e.g. IDs
+	err       string
+	want      string
+	event     string
+	check     func(t *testing.T, payload []byte)
+}
+
+// run executes the cases in order; later cases see the state committed by
+// earlier ones.
+func (c *coinsTest) run(t *testing.T, cases []txCase) {
+	t.Helper()
+
+	for _, tc := range cases {
+		tc := tc
+		ok := t.Run(tc.name, func(t *testing.T) {
+			c.network.lastEvent = nil
+
+			args := tc.args
+			if tc.argsOf != nil {
+				args = tc.argsOf()
+			}
+
+			chaincodeName := coinsChaincode
+			if len(tc.chaincode) != 0 {
+				chaincodeName = tc.chaincode
+			}
+
+			response := c.network.invoke(tc.caller, chaincodeName, tc.function, args...)
+
+			if len(tc.err) != 0 {
+				if response.Status == shim.OK {
+					t.Fatalf("%s succeeded with %s, expected error %s", tc.function, response.Payload, tc.err)
+				}
+				if !strings.HasPrefix(response.Message, tc.err) {
+					t.Fatalf("%s failed with %q, expected %q", tc.function, response.Message, tc.err)
+				}
+				return
+			}
+
+			if response.Status != shim.OK {
+				t.Fatalf("%s failed: %s", tc.function, response.Message)
+			}
+
+			if len(tc.want) != 0 {
+				assertContains(t, tc.want, response.Payload)
+			}
+
+			if len(tc.event) != 0 {
+				if c.network.lastEvent == nil || c.network.lastEvent.EventName != tc.event {
+					t.Fatalf("expected event %s, got %v", tc.event, c.network.lastEvent)
+				}
+			}
+
+			if tc.check != nil {
+				tc.check(t, response.Payload)
+			}
+		})
+
+		// Later cases depend on this one
+		if !ok {
+			t.FailNow()
+		}
+	}
+}
+
+// saveId returns a check which stores the id field of the payload, e.g.
+// the ID of a mint proposal, for later cases.
+func saveId(id *string) func(t *testing.T, payload []byte) {
+	return func(t *testing.T, payload []byte) {
+		var record struct {
+			Id string `json:"id"`
+		}
+		mustUnmarshal(t, payload, &record)
+		*id = record.Id
+	}
+}
+
+// assertContains checks that the payload has all the fields of the expected
+// JSON value. Payloads which are not JSON are compared as strings.
+func assertContains(t *testing.T, want string, payload []byte) {
+	t.Helper()
+
+	var expected, actual interface{}
+	if json.Unmarshal([]byte(want), &expected) != nil || json.Unmarshal(payload, &actual) != nil {
+		if string(payload) != want {
+			t.Fatalf("expected %s, got %s", want, payload)
+		}
+		return
+	}
+
+	if !containsValue(expected, actual) {
+		t.Fatalf("expected %s, got %s", want, payload)
+	}
+}
+
+func containsValue(expected interface{}, actual interface{}) bool {
+	switch expectedValue := expected.(type) {
+	case map[string]interface{}:
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range expectedValue {
+			if !containsValue(value, actualMap[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		actualSlice, ok := actual.([]interface{})
+		if !ok || len(actualSlice) != len(expectedValue) {
+			return false
+		}
+		for i := range expectedValue {
+			if !containsValue(expectedValue[i], actualSlice[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(expected, actual)
+	}
+}
+
+// account returns the composite account key used in responses.
+func account(accountType string, accountId string) string {
+	key, _ := shim.CreateCompositeKey(accountType, []string{accountId})
+	return key
+}
+
+// jsonString quotes a value for expected JSON, e.g. an account key.
+func jsonString(value string) string {
+	valueBytes, _ := json.Marshal(value)
+	return string(valueBytes)
+}
+
+// eventOf decodes the payload of the last committed event.
+func (c *coinsTest) eventOf(t *testing.T, event interface{}) {
+	t.Helper()
+
+	if c.network.lastEvent == nil {
+		t.Fatal("no event")
+	}
+
+	err := json.Unmarshal(c.network.lastEvent.Payload, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMockStubFabricSemantics(t *testing.T) {
+	network := newMockNetwork()
+	network.register(echoChaincode, new(mockEchoChaincode))
+
+	tx := &mockTx{network: network, id: "tx1", timestamp: &timestamp.Timestamp{}, writes: make(map[string]map[string]*mockWrite)}
+	tx.writes[echoChaincode] = make(map[string]*mockWrite)
+	stub := &mockStub{tx: tx, chaincode: echoChaincode}
+
+	key, err := stub.CreateCompositeKey("balance", []string{"user_", "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectType, attributes, err := stub.SplitCompositeKey(key)
+	if err != nil || objectType != "balance" || !reflect.DeepEqual(attributes, []string{"user_", "alice"}) {
+		t.Fatalf("split %q: %s %v %v", key, objectType, attributes, err)
+	}
+
+	err = stub.PutState(key, []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := stub.GetState(key)
+	if err != nil || value != nil {
+		t.Fatalf("uncommitted write is visible: %q %v", value, err)
+	}
+
+	tx.commit()
+
+	value, err = stub.GetState(key)
+	if err != nil || string(value) != "1" {
+		t.Fatalf("committed write is not visible: %q %v", value, err)
+	}
+
+	tx.writes[echoChaincode] = map[string]*mockWrite{key: {delete: true}}
+	tx.commit()
+
+	history, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modification, err := history.Next()
+	if err != nil || !modification.IsDelete || !history.HasNext() {
+		t.Fatalf("expected the delete first: %v %v", modification, err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		network.setState(echoChaincode, "key_"+id, []byte(id))
+	}
+
+	iterator, metadata, err := stub.GetStateByRangeWithPagination("key_", "", 2, "")
+	if err != nil || metadata.Bookmark != "key_c" || metadata.FetchedRecordsCount != 2 {
+		t.Fatalf("first page: %v %v", metadata, err)
+	}
+	iterator.Close()
+
+	iterator, metadata, err = stub.GetStateByRangeWithPagination("key_", "", 2, metadata.Bookmark)
+	if err != nil || metadata.Bookmark != "" || metadata.FetchedRecordsCount != 1 {
+		t.Fatalf("last page: %v %v", metadata, err)
+	}
+	iterator.Close()
+
+	response := stub.InvokeChaincode(echoChaincode, [][]byte{[]byte("f"), []byte("alice")}, "")
+	if response.Status != shim.OK || string(response.Payload) != "f alice" {
+		t.Fatalf("routed call failed: %s %s", response.Message, response.Payload)
+	}
+
+	response = stub.InvokeChaincode("unknown", [][]byte{[]byte("f")}, "")
+	if response.Status == shim.OK {
+		t.Fatal("call of an unregistered chaincode succeeded")
+	}
+}
+
+func TestMockIdentity(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "common name", caller: test.minter, function: "Mint", args: []string{"", "10"}, want: `{"userId":` + jsonString(account(userAccountType, "sj_coin")) + `}`},
+		{name: "other common name", caller: test.alice, function: "Mint", args: []string{"", "10"}, err: noPermissionsCode},
+		{name: "user ID attribute", caller: test.minter, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}},
+		{name: "role attribute", caller: test.admin, function: "HasRole", args: []string{adminRole, "admin"}, want: "true"},
+		{name: "user ID attribute spends", caller: test.bob, function: "Transfer", args: []string{"", "user_", "alice", "1", "", "", ""}, want: `{"userId":` + jsonString(account(userAccountType, "bob")) + `}`},
+	})
+}
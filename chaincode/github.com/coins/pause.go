@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// While the contract is paused no coins can be moved and no allowances
+// changed, queries and administration keep working. Frozen accounts can
+// neither send nor receive coins.
+var pausedKey = "paused"
+var frozenPrefix = "frozen"
+
+type PauseInfo struct {
+	Paused   bool   `json:"paused"`
+	Reason   string `json:"reason"`
+	PausedBy string `json:"pausedBy"`
+	Since    string `json:"since"`
+}
+
+type FreezeInfo struct {
+	AccountType string `json:"accountType"`
+	AccountId   string `json:"accountId"`
+	Frozen      bool   `json:"frozen"`
+	Reason      string `json:"reason"`
+	FrozenBy    string `json:"frozenBy"`
+	Since       string `json:"since"`
+}
+
+func (t *CoinChain) Pause(ctx contractapi.TransactionContextInterface, reason string) (*PauseInfo, error) {
+
+	fmt.Println("pause: " + reason)
+
+	currentUserId, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pauseInfo := &PauseInfo{Paused: true, Reason: reason, PausedBy: currentUserId, Since: txTime.Format(time.RFC3339Nano)}
+
+	pauseBytes, err := json.Marshal(pauseInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(pausedKey, pauseBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return pauseInfo, nil
+}
+
+func (t *CoinChain) Unpause(ctx contractapi.TransactionContextInterface) error {
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(pausedKey)
+}
+
+func (t *CoinChain) PauseStatus(ctx contractapi.TransactionContextInterface) (*PauseInfo, error) {
+
+	pauseBytes, err := ctx.GetStub().GetState(pausedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pauseInfo := new(PauseInfo)
+	if len(pauseBytes) == 0 {
+		return pauseInfo, nil
+	}
+
+	err = json.Unmarshal(pauseBytes, pauseInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return pauseInfo, nil
+}
+
+func (t *CoinChain) FreezeAccount(ctx contractapi.TransactionContextInterface, accountType string, accountId string, reason string) (*FreezeInfo, error) {
+
+	fmt.Println("freeze " + accountType + accountId + ": " + reason)
+
+	if len(reason) == 0 {
+		return nil, newError(invalidArgumentCode, "reason is required")
+	}
+
+	if isInternalAccountType(accountType) {
+		return nil, newError(invalidArgumentCode, "%s accounts can not be frozen", accountType)
+	}
+
+	currentUserId, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(frozenPrefix, []string{accountType, accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	freezeInfo := &FreezeInfo{
+		AccountType: accountType,
+		AccountId:   accountId,
+		Frozen:      true,
+		Reason:      reason,
+		FrozenBy:    currentUserId,
+		Since:       txTime.Format(time.RFC3339Nano),
+	}
+
+	freezeBytes, err := json.Marshal(freezeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(key, freezeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return freezeInfo, nil
+}
+
+func (t *CoinChain) UnfreezeAccount(ctx contractapi.TransactionContextInterface, accountType string, accountId string) error {
+
+	fmt.Println("unfreeze " + accountType + accountId)
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(frozenPrefix, []string{accountType, accountId})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+func (t *CoinChain) FreezeStatus(ctx contractapi.TransactionContextInterface, accountType string, accountId string) (*FreezeInfo, error) {
+
+	account, err := ctx.GetStub().CreateCompositeKey(accountType, []string{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	freezeInfo, err := getFreezeInfo(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if freezeInfo == nil {
+		freezeInfo = &FreezeInfo{AccountType: accountType, AccountId: accountId}
+	}
+
+	return freezeInfo, nil
+}
+
+func checkNotPaused(ctx contractapi.TransactionContextInterface) error {
+
+	pauseBytes, err := ctx.GetStub().GetState(pausedKey)
+	if err != nil {
+		return err
+	}
+
+	if len(pauseBytes) != 0 {
+		return newError(pausedCode, "contract is paused")
+	}
+
+	return nil
+}
+
+// checkNotFrozen fails if the account composite key belongs to a frozen account.
+func (s *balanceSheet) checkNotFrozen(account string) error {
+	frozen, ok := s.frozen[account]
+
+	if !ok {
+		freezeInfo, err := getFreezeInfo(s.ctx, account)
+		if err != nil {
+			return err
+		}
+
+		frozen = freezeInfo != nil
+		s.frozen[account] = frozen
+	}
+
+	if frozen {
+		_, accountId, err := splitAccount(s.ctx, account)
+		if err != nil {
+			return err
+		}
+		return newError(accountFrozenCode, "account %s is frozen", accountId)
+	}
+
+	return nil
+}
+
+// getFreezeInfo returns nil if the account is not frozen.
+func getFreezeInfo(ctx contractapi.TransactionContextInterface, account string) (*FreezeInfo, error) {
+
+	accountType, accountId, err := splitAccount(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(frozenPrefix, []string{accountType, accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	freezeBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(freezeBytes) == 0 {
+		return nil, nil
+	}
+
+	freezeInfo := new(FreezeInfo)
+	err = json.Unmarshal(freezeBytes, freezeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return freezeInfo, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestPause(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "fund alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "10", "", "", ""}},
+
+		{name: "pause by user", caller: test.alice, function: "Pause", args: []string{"incident"}, err: noPermissionsCode},
+		{name: "pause", caller: test.admin, function: "Pause", args: []string{"incident"}, want: `{"paused":true,"reason":"incident","pausedBy":"admin","since":"2020-01-01T00:00:00Z"}`},
+		{name: "paused status", caller: test.alice, function: "PauseStatus", want: `{"paused":true,"reason":"incident"}`},
+		{name: "transfer while paused", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, err: pausedCode},
+		{name: "mint while paused", caller: test.minter, function: "Mint", args: []string{"", "1"}, err: pausedCode},
+		{name: "approve while paused", caller: test.alice, function: "Approve", args: []string{"", "user_", "bob", "1"}, err: pausedCode},
+		{name: "query while paused", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"10"}`},
+
+		{name: "unpause by user", caller: test.alice, function: "Unpause", err: noPermissionsCode},
+		{name: "unpause", caller: test.admin, function: "Unpause"},
+		{name: "running status", caller: test.alice, function: "PauseStatus", want: `{"paused":false,"reason":""}`},
+		{name: "transfer after unpause", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, want: `{"balance":"9"}`},
+	})
+}
+
+func TestFreeze(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "fund alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "10", "", "", ""}},
+
+		{name: "freeze without reason", caller: test.admin, function: "FreezeAccount", args: []string{"user_", "alice", ""}, err: invalidArgumentCode},
+		{name: "freeze by user", caller: test.bob, function: "FreezeAccount", args: []string{"user_", "alice", "fraud"}, err: noPermissionsCode},
+		{name: "freeze vesting account", caller: test.admin, function: "FreezeAccount", args: []string{"vesting_", "g1", "fraud"}, err: invalidArgumentCode},
+		{name: "freeze", caller: test.admin, function: "FreezeAccount", args: []string{"user_", "alice", "fraud"},
+			want: `{"accountType":"user_","accountId":"alice","frozen":true,"reason":"fraud","frozenBy":"admin"}`},
+		{name: "frozen status", caller: test.bob, function: "FreezeStatus", args: []string{"user_", "alice"}, want: `{"frozen":true,"reason":"fraud"}`},
+		{name: "send from frozen account", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, err: accountFrozenCode},
+		{name: "send to frozen account", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "1", "", "", ""}, err: accountFrozenCode},
+		{name: "burn frozen coins", caller: test.alice, function: "Burn", args: []string{"", "user_", "alice", "1", "lost key"}, err: accountFrozenCode},
+		{name: "burn frozen coins by minter", caller: test.minter, function: "Burn", args: []string{"", "user_", "alice", "1", "fine"}, err: accountFrozenCode},
+
+		{name: "unfreeze by user", caller: test.bob, function: "UnfreezeAccount", args: []string{"user_", "alice"}, err: noPermissionsCode},
+		{name: "unfreeze", caller: test.admin, function: "UnfreezeAccount", args: []string{"user_", "alice"}},
+		{name: "unfrozen status", caller: test.bob, function: "FreezeStatus", args: []string{"user_", "alice"}, want: `{"accountType":"user_","accountId":"alice","frozen":false}`},
+		{name: "send after unfreeze", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "", "", ""}, want: `{"balance":"9"}`},
+	})
+}
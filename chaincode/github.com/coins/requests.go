@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Payments with a client request ID are recorded under
+// request~<caller id>~<request id>, so a retried payment returns the result
+// of the first one instead of moving coins again.
+var requestPrefix = "request"
+
+type RequestRecord struct {
+	RequestId string       `json:"requestId"`
+	Function  string       `json:"function"`
+	ArgsHash  string       `json:"argsHash"`
+	TxId      string       `json:"txId"`
+	Timestamp string       `json:"timestamp"`
+	Result    *UserBalance `json:"result"`
+}
+
+// RequestOutcome returns the result of a payment the current user made with
+// the given request ID.
+func (t *CoinChain) RequestOutcome(ctx contractapi.TransactionContextInterface, requestId string) (*RequestRecord, error) {
+
+	fmt.Println("request " + requestId)
+
+	if len(requestId) == 0 {
+		return nil, newError(invalidArgumentCode, "request ID is required")
+	}
+
+	record, err := getRequestRecord(ctx, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil {
+		return nil, newError(notFoundCode, "request %s not found", requestId)
+	}
+
+	return record, nil
+}
+
+// findRequest returns the record of an already processed request, nil if the
+// request is new or has no ID. Reusing the ID for a different call is an error.
+func findRequest(ctx contractapi.TransactionContextInterface, requestId string) (*RequestRecord, error) {
+	if len(requestId) == 0 {
+		return nil, nil
+	}
+
+	record, err := getRequestRecord(ctx, requestId)
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	function, argsHash := requestCall(ctx)
+	if record.Function != function || record.ArgsHash != argsHash {
+		return nil, newError(duplicateRequestCode, "request ID %s was already used for another payment", requestId)
+	}
+
+	fmt.Println("replayed request " + requestId + " of tx " + record.TxId)
+	return record, nil
+}
+
+// saveRequest records the result of a request, does nothing if it has no ID.
+func saveRequest(ctx contractapi.TransactionContextInterface, requestId string, result *UserBalance) error {
+	if len(requestId) == 0 {
+		return nil
+	}
+
+	key, err := requestKey(ctx, requestId)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	function, argsHash := requestCall(ctx)
+
+	record := &RequestRecord{
+		RequestId: requestId,
+		Function:  function,
+		ArgsHash:  argsHash,
+		TxId:      ctx.GetStub().GetTxID(),
+		Timestamp: txTime.Format(time.RFC3339Nano),
+		Result:    result,
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, recordBytes)
+}
+
+func getRequestRecord(ctx contractapi.TransactionContextInterface, requestId string) (*RequestRecord, error) {
+	key, err := requestKey(ctx, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	recordBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recordBytes) == 0 {
+		return nil, nil
+	}
+
+	record := new(RequestRecord)
+	err = json.Unmarshal(recordBytes, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func requestKey(ctx contractapi.TransactionContextInterface, requestId string) (string, error) {
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return ctx.GetStub().CreateCompositeKey(requestPrefix, []string{currentUserId, requestId})
+}
+
+// requestCall returns the invoked function and a hash of its arguments.
+func requestCall(ctx contractapi.TransactionContextInterface) (string, string) {
+	function, args := ctx.GetStub().GetFunctionAndParameters()
+
+	argsBytes, _ := json.Marshal(args)
+	hash := sha256.Sum256(argsBytes)
+
+	return function, hex.EncodeToString(hash[:])
+}
+
+// requestResult returns the balance of account and the charged fee (nil if
+// none) as the transaction response and records it for the request.
+func (s *balanceSheet) requestResult(requestId string, account string, fee *big.Int) (*UserBalance, error) {
+	result, err := s.userBalance(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if fee != nil {
+		result.Fee = formatAmount(fee, s.decimals)
+	}
+
+	err = saveRequest(s.ctx, requestId, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestRequests(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "outcome without ID", caller: test.minter, function: "RequestOutcome", args: []string{""}, err: invalidArgumentCode},
+		{name: "outcome of unknown request", caller: test.minter, function: "RequestOutcome", args: []string{"r1"}, err: notFoundCode},
+
+		{name: "transfer", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "10", "r1", "", ""}, want: `{"balance":"90"}`},
+		{name: "outcome", caller: test.minter, function: "RequestOutcome", args: []string{"r1"},
+			want: `{"requestId":"r1","function":"Transfer","timestamp":"2020-01-01T00:00:00Z","result":{"balance":"90"}}`},
+		{name: "outcome of another user", caller: test.alice, function: "RequestOutcome", args: []string{"r1"}, err: notFoundCode},
+		{name: "transfer retried", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "10", "r1", "", ""}, want: `{"balance":"90"}`},
+		{name: "request ID of another function", caller: test.minter, function: "BatchTransfer", args: []string{"", `[{"userId":"alice","amount":"10"}]`, "r1"}, err: duplicateRequestCode},
+		{name: "same request ID of another user", caller: test.alice, function: "Transfer", args: []string{"", "user_", "bob", "1", "r1", "", ""}, want: `{"balance":"9"}`},
+
+		{name: "batch transfer", caller: test.minter, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"5"}]`, "r2"}, want: `{"balance":"85"}`},
+		{name: "batch transfer retried", caller: test.minter, function: "BatchTransfer", args: []string{"", `[{"userId":"bob","amount":"5"}]`, "r2"}, want: `{"balance":"85"}`},
+		{name: "donate", caller: test.alice, function: "Transfer", args: []string{"", "project_", "p1", "4", "", "", ""}},
+		{name: "refund", caller: test.minter, function: "Refund", args: []string{"", "p1", "alice", "4", "r3"}, want: `{"balance":"0"}`},
+		{name: "refund retried", caller: test.minter, function: "Refund", args: []string{"", "p1", "alice", "4", "r3"}, want: `{"balance":"0"}`},
+		{name: "balance after retries", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"9"}`},
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles are granted either by the coins.role enrollment attribute (comma
+// separated list of roles) or by the on-ledger registry stored under
+// role~<role>~<user id>. The minter set by InitLedger holds every role.
+var rolePrefix = "role"
+var roleAttribute = "coins.role"
+
+var minterRole = "minter"
+var refundOperatorRole = "refund-operator"
+var auditorRole = "auditor"
+var adminRole = "admin"
+
+var roles = map[string]bool{
+	minterRole:         true,
+	refundOperatorRole: true,
+	auditorRole:        true,
+	adminRole:          true,
+}
+
+func (t *CoinChain) GrantRole(ctx contractapi.TransactionContextInterface, role string, userId string) error {
+
+	fmt.Println("grant role " + role + " to " + userId)
+
+	if !roles[role] {
+		return newError(invalidArgumentCode, "unknown role %s", role)
+	}
+
+	if len(userId) == 0 {
+		return newError(invalidArgumentCode, "incorrect user ID")
+	}
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(rolePrefix, []string{role, userId})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte(userId))
+}
+
+// RevokeRole removes a role from the on-ledger registry. Roles granted by
+// enrollment attributes must be revoked by the certificate authority.
+func (t *CoinChain) RevokeRole(ctx contractapi.TransactionContextInterface, role string, userId string) error {
+
+	fmt.Println("revoke role " + role + " from " + userId)
+
+	if !roles[role] {
+		return newError(invalidArgumentCode, "unknown role %s", role)
+	}
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(rolePrefix, []string{role, userId})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// HasRole checks the on-ledger registry and the minter. Enrollment attributes
+// are checked only when userId is the caller.
+func (t *CoinChain) HasRole(ctx contractapi.TransactionContextInterface, role string, userId string) (bool, error) {
+
+	if !roles[role] {
+		return false, newError(invalidArgumentCode, "unknown role %s", role)
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if userId == currentUserId {
+		return hasCurrentUserRole(ctx, currentUserId, role)
+	}
+
+	return hasRole(ctx, userId, role)
+}
+
+// checkRole returns the caller ID if the caller holds the role.
+func checkRole(ctx contractapi.TransactionContextInterface, role string) (string, error) {
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := hasCurrentUserRole(ctx, currentUserId, role)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", newError(noPermissionsCode, "no permissions")
+	}
+
+	return currentUserId, nil
+}
+
+func hasCurrentUserRole(ctx contractapi.TransactionContextInterface, currentUserId string, role string) (bool, error) {
+
+	attributeRoles, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return false, err
+	}
+
+	if found {
+		for _, attributeRole := range strings.Split(attributeRoles, ",") {
+			if strings.TrimSpace(attributeRole) == role {
+				return true, nil
+			}
+		}
+	}
+
+	return hasRole(ctx, currentUserId, role)
+}
+
+func hasRole(ctx contractapi.TransactionContextInterface, userId string, role string) (bool, error) {
+
+	minterBytes, err := ctx.GetStub().GetState(minterKey)
+	if err != nil {
+		return false, err
+	}
+
+	if userId == string(minterBytes) {
+		return true, nil
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(rolePrefix, []string{role, userId})
+	if err != nil {
+		return false, err
+	}
+
+	roleBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, err
+	}
+
+	return len(roleBytes) != 0, nil
+}
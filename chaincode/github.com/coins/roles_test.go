@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestRoles(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "grant by user", caller: test.alice, function: "GrantRole", args: []string{auditorRole, "alice"}, err: noPermissionsCode},
+		{name: "grant unknown role", caller: test.admin, function: "GrantRole", args: []string{"owner", "alice"}, err: invalidArgumentCode},
+		{name: "grant to nobody", caller: test.admin, function: "GrantRole", args: []string{auditorRole, ""}, err: invalidArgumentCode},
+		{name: "role missing", caller: test.alice, function: "HasRole", args: []string{auditorRole, "alice"}, want: "false"},
+		{name: "list balances without role", caller: test.alice, function: "ListBalances", args: []string{"", "", "10", "", "0"}, err: noPermissionsCode},
+
+		{name: "grant", caller: test.admin, function: "GrantRole", args: []string{auditorRole, "alice"}},
+		{name: "granted role", caller: test.bob, function: "HasRole", args: []string{auditorRole, "alice"}, want: "true"},
+		{name: "other roles", caller: test.bob, function: "HasRole", args: []string{adminRole, "alice"}, want: "false"},
+		{name: "list balances with role", caller: test.alice, function: "ListBalances", args: []string{"", "", "10", "", "0"}, want: `{"balances":[]}`},
+
+		{name: "revoke by user", caller: test.alice, function: "RevokeRole", args: []string{auditorRole, "alice"}, err: noPermissionsCode},
+		{name: "revoke unknown role", caller: test.admin, function: "RevokeRole", args: []string{"owner", "alice"}, err: invalidArgumentCode},
+		{name: "revoke", caller: test.admin, function: "RevokeRole", args: []string{auditorRole, "alice"}},
+		{name: "revoked role", caller: test.alice, function: "HasRole", args: []string{auditorRole, "alice"}, want: "false"},
+
+		{name: "minter holds every role", caller: test.alice, function: "HasRole", args: []string{refundOperatorRole, "sj_coin"}, want: "true"},
+		{name: "attribute role of others is unknown", caller: test.alice, function: "HasRole", args: []string{adminRole, "admin"}, want: "false"},
+		{name: "unknown role", caller: test.alice, function: "HasRole", args: []string{"owner", "alice"}, err: invalidArgumentCode},
+	})
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Standing orders pay a fixed amount from the payer to a user every interval
+// between start and end. They are stored under schedule~<schedule id> and
+// paid by ExecuteDueSchedules, which finds active schedules by the index
+// scheduleDue~<currency>~<next run>~<schedule id>. Payments which fail are
+// skipped and recorded under scheduleFailure~<schedule id>~<due time>.
+var schedulePrefix = "schedule"
+var scheduleDuePrefix = "scheduleDue"
+var scheduleFailurePrefix = "scheduleFailure"
+
+var minScheduleInterval int64 = 60
+
+// Payments of one schedule made by a single ExecuteDueSchedules call, the
+// rest of a long overdue schedule is paid by the next calls.
+var maxScheduledPayments = 100
+
+type Schedule struct {
+	Id              string `json:"id"`
+	Currency        string `json:"currency"`
+	Payer           string `json:"payer"`
+	Receiver        string `json:"receiver"`
+	Amount          string `json:"amount"`
+	IntervalSeconds int64  `json:"intervalSeconds"`
+	Start           string `json:"start"`
+	End             string `json:"end"`
+	NextRun         string `json:"nextRun"`
+	Payments        int    `json:"payments"`
+	Failures        int    `json:"failures"`
+	Active          bool   `json:"active"`
+}
+
+type ScheduleFailure struct {
+	ScheduleId string `json:"scheduleId"`
+	Due        string `json:"due"`
+	TxId       string `json:"txId"`
+	Error      string `json:"error"`
+}
+
+// ScheduleRun is the result of ExecuteDueSchedules. More is true if due
+// schedules were left for the next call.
+type ScheduleRun struct {
+	Payments int                `json:"payments"`
+	Failures []*ScheduleFailure `json:"failures"`
+	More     bool               `json:"more"`
+}
+
+// CreateSchedule creates a standing order of the current user paying amount
+// coins to the receiver every intervalSeconds from start until end (RFC3339,
+// empty end for no end). The transaction ID is the schedule ID.
+func (t *CoinChain) CreateSchedule(ctx contractapi.TransactionContextInterface, currency string, receiver string, amount string, intervalSeconds int64, start string, end string) (*Schedule, error) {
+
+	fmt.Println("schedule to " + receiver + ", amount " + amount)
+	fmt.Println("interval ", intervalSeconds, ", start "+start+", end "+end)
+
+	err := validateReceiver(userAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	if intervalSeconds < minScheduleInterval {
+		return nil, newError(invalidArgumentCode, "interval must be at least %d seconds", minScheduleInterval)
+	}
+
+	startTime, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil || startTime.Unix() < 0 {
+		return nil, newError(invalidArgumentCode, "incorrect start %s", start)
+	}
+
+	if len(end) != 0 {
+		endTime, err := time.Parse(time.RFC3339Nano, end)
+		if err != nil {
+			return nil, newError(invalidArgumentCode, "incorrect end %s", end)
+		}
+
+		if endTime.Before(startTime) {
+			return nil, newError(invalidArgumentCode, "end is before start")
+		}
+		end = endTime.UTC().Format(time.RFC3339Nano)
+	}
+
+	currencyInfo, err := getCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, currencyInfo.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if receiver == currentUserId {
+		return nil, newError(invalidReceiverCode, "can not transfer coins to yourself")
+	}
+
+	err = checkReceiver(ctx, userAccountType, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every payment is checked against the limits when it is made, the
+	// maximum transfer is checked early as well
+	limits, _, err := getLimits(ctx, currencyInfo.Symbol, currentUserId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLimit(limits.MaxTransfer, value, currencyInfo.Decimals, "transfer exceeds the maximum of %s")
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNotPaused(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &Schedule{
+		Id:              ctx.GetStub().GetTxID(),
+		Currency:        currencyInfo.Symbol,
+		Payer:           currentUserId,
+		Receiver:        receiver,
+		Amount:          formatAmount(value, currencyInfo.Decimals),
+		IntervalSeconds: intervalSeconds,
+		Start:           startTime.UTC().Format(time.RFC3339Nano),
+		End:             end,
+		NextRun:         startTime.UTC().Format(time.RFC3339Nano),
+		Active:          true,
+	}
+
+	err = saveSchedule(ctx, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// CancelSchedule stops a standing order. The payer or an admin can cancel it.
+func (t *CoinChain) CancelSchedule(ctx contractapi.TransactionContextInterface, scheduleId string) (*Schedule, error) {
+
+	fmt.Println("cancel schedule " + scheduleId)
+
+	schedule, err := getSchedule(ctx, scheduleId)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUserId != schedule.Payer {
+		_, err = checkRole(ctx, adminRole)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = unindexSchedule(ctx, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Active = false
+
+	err = saveSchedule(ctx, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// ExecuteDueSchedules pays every payment in the currency due as of the
+// transaction timestamp, of at most maxSchedules schedules. Anyone can call
+// it. Payments which can not be made are skipped and recorded.
+func (t *CoinChain) ExecuteDueSchedules(ctx contractapi.TransactionContextInterface, currency string, maxSchedules int) (*ScheduleRun, error) {
+
+	fmt.Println("execute schedules " + currency)
+
+	if maxSchedules <= 0 {
+		return nil, newError(invalidArgumentCode, "incorrect max schedules %d", maxSchedules)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	feePolicy, err := getFeePolicy(ctx, sheet.currency.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is sorted by the next run, so the scan stops at the first
+	// schedule which is not due yet
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(scheduleDuePrefix, []string{sheet.currency.Symbol})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	run := &ScheduleRun{Failures: []*ScheduleFailure{}}
+	schedules := 0
+
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		nanos, err := strconv.ParseInt(attributes[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Unix(0, nanos).After(txTime) {
+			break
+		}
+
+		if schedules == maxSchedules {
+			run.More = true
+			break
+		}
+		schedules++
+
+		schedule, err := getSchedule(ctx, attributes[2])
+		if err != nil {
+			return nil, err
+		}
+
+		err = unindexSchedule(ctx, schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		due, err := time.Parse(time.RFC3339Nano, schedule.NextRun)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < maxScheduledPayments && !due.After(txTime); i++ {
+			if schedule.End != "" {
+				endTime, err := time.Parse(time.RFC3339Nano, schedule.End)
+				if err != nil {
+					return nil, err
+				}
+				if due.After(endTime) {
+					schedule.Active = false
+					break
+				}
+			}
+
+			payErr := sheet.payScheduled(feePolicy, schedule)
+			if payErr == nil {
+				schedule.Payments++
+				run.Payments++
+			} else if coinErr, ok := payErr.(*CoinError); ok {
+				failure, err := saveScheduleFailure(ctx, schedule, due, coinErr)
+				if err != nil {
+					return nil, err
+				}
+				schedule.Failures++
+				run.Failures = append(run.Failures, failure)
+			} else {
+				return nil, payErr
+			}
+
+			due = due.Add(time.Duration(schedule.IntervalSeconds) * time.Second)
+		}
+
+		schedule.NextRun = due.Format(time.RFC3339Nano)
+
+		err = saveSchedule(ctx, schedule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	if run.Payments > 0 {
+		err = sheet.emit(transferEventName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return run, nil
+}
+
+func (t *CoinChain) GetSchedule(ctx contractapi.TransactionContextInterface, scheduleId string) (*Schedule, error) {
+	return getSchedule(ctx, scheduleId)
+}
+
+// ScheduleFailures returns payments of the schedule which were skipped.
+func (t *CoinChain) ScheduleFailures(ctx contractapi.TransactionContextInterface, scheduleId string) ([]*ScheduleFailure, error) {
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(scheduleFailurePrefix, []string{scheduleId})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	failures := []*ScheduleFailure{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		failure := new(ScheduleFailure)
+		err = json.Unmarshal(entry.Value, failure)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+
+	return failures, nil
+}
+
+// payScheduled makes one payment of the schedule or changes nothing if the
+// payment can not be made. All checks come before the first movement: an
+// error after it is not a skipped payment and fails the whole transaction.
+func (s *balanceSheet) payScheduled(feePolicy *FeePolicy, schedule *Schedule) error {
+	value, err := parseAmount(schedule.Amount, s.decimals)
+	if err != nil {
+		return err
+	}
+
+	payerAccount, err := s.ctx.GetStub().CreateCompositeKey(userAccountType, []string{schedule.Payer})
+	if err != nil {
+		return err
+	}
+
+	receiverAccount, err := s.ctx.GetStub().CreateCompositeKey(userAccountType, []string{schedule.Receiver})
+	if err != nil {
+		return err
+	}
+
+	err = s.checkNotFrozen(payerAccount)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkNotFrozen(receiverAccount)
+	if err != nil {
+		return err
+	}
+
+	fee, err := s.feeOf(feePolicy, payerAccount, receiverAccount, value)
+	if err != nil {
+		return err
+	}
+
+	spent := new(big.Int).Add(value, fee)
+
+	balance, err := s.balanceOf(payerAccount)
+	if err != nil {
+		return err
+	}
+
+	if balance.Cmp(spent) < 0 {
+		return newError(insufficientFundsCode, "not enough coins")
+	}
+
+	if fee.Sign() > 0 {
+		treasuryAccount, err := s.ctx.GetStub().CreateCompositeKey(treasuryAccountType, []string{feePolicy.Treasury})
+		if err != nil {
+			return err
+		}
+
+		err = s.checkNotFrozen(treasuryAccount)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkLimits(s.ctx, s, payerAccount, []*big.Int{spent}, false)
+	if err != nil {
+		return err
+	}
+
+	err = s.transferWithMemo(payerAccount, receiverAccount, value, "Scheduled payment", schedule.Id)
+	if err != nil {
+		return fmt.Errorf("scheduled payment %s: %s", schedule.Id, err.Error())
+	}
+
+	_, err = s.chargeFee(feePolicy, payerAccount, receiverAccount, value)
+	if err != nil {
+		return fmt.Errorf("fee of scheduled payment %s: %s", schedule.Id, err.Error())
+	}
+
+	return nil
+}
+
+func getSchedule(ctx contractapi.TransactionContextInterface, scheduleId string) (*Schedule, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(schedulePrefix, []string{scheduleId})
+	if err != nil {
+		return nil, err
+	}
+
+	scheduleBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(scheduleBytes) == 0 {
+		return nil, newError(notFoundCode, "schedule %s not found", scheduleId)
+	}
+
+	schedule := new(Schedule)
+	err = json.Unmarshal(scheduleBytes, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// saveSchedule stores the schedule and indexes it by the next run if it is
+// active. Callers changing the next run or deactivating the schedule remove
+// the old index entry with unindexSchedule first.
+func saveSchedule(ctx contractapi.TransactionContextInterface, schedule *Schedule) error {
+	key, err := ctx.GetStub().CreateCompositeKey(schedulePrefix, []string{schedule.Id})
+	if err != nil {
+		return err
+	}
+
+	scheduleBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(key, scheduleBytes)
+	if err != nil {
+		return err
+	}
+
+	if !schedule.Active {
+		return nil
+	}
+
+	dueKey, err := scheduleDueKey(ctx, schedule)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(dueKey, []byte{0x00})
+}
+
+func unindexSchedule(ctx contractapi.TransactionContextInterface, schedule *Schedule) error {
+	dueKey, err := scheduleDueKey(ctx, schedule)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(dueKey)
+}
+
+func scheduleDueKey(ctx contractapi.TransactionContextInterface, schedule *Schedule) (string, error) {
+	nextRun, err := time.Parse(time.RFC3339Nano, schedule.NextRun)
+	if err != nil {
+		return "", err
+	}
+
+	return ctx.GetStub().CreateCompositeKey(scheduleDuePrefix, []string{schedule.Currency, fmt.Sprintf("%020d", nextRun.UnixNano()), schedule.Id})
+}
+
+func saveScheduleFailure(ctx contractapi.TransactionContextInterface, schedule *Schedule, due time.Time, cause error) (*ScheduleFailure, error) {
+	failure := &ScheduleFailure{
+		ScheduleId: schedule.Id,
+		Due:        due.Format(time.RFC3339Nano),
+		TxId:       ctx.GetStub().GetTxID(),
+		Error:      cause.Error(),
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(scheduleFailurePrefix, []string{schedule.Id, fmt.Sprintf("%020d", due.UnixNano())})
+	if err != nil {
+		return nil, err
+	}
+
+	failureBytes, err := json.Marshal(failure)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutState(key, failureBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return failure, nil
+}
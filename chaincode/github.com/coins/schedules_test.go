@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedules(t *testing.T) {
+	test := newCoinsTest(t)
+	var scheduleId string
+	schedule := func() []string { return []string{scheduleId} }
+	advance := func(t *testing.T, payload []byte) { test.network.advance(2 * time.Hour) }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "25", "", "", ""}},
+
+		{name: "create with short interval", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "10", "30", "2020-01-01T00:00:00Z", ""}, err: invalidArgumentCode},
+		{name: "create with bad start", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "10", "3600", "now", ""}, err: invalidArgumentCode},
+		{name: "create ending before start", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "10", "3600", "2020-01-01T00:00:00Z", "2019-01-01T00:00:00Z"}, err: invalidArgumentCode},
+		{name: "create for yourself", caller: test.alice, function: "CreateSchedule", args: []string{"", "alice", "10", "3600", "2020-01-01T00:00:00Z", ""}, err: invalidReceiverCode},
+		{name: "create with bad amount", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "-1", "3600", "2020-01-01T00:00:00Z", ""}, err: invalidAmountCode},
+		{name: "create", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "10", "3600", "2020-01-01T00:00:00Z", "2020-01-01T05:00:00Z"},
+			want: `{"payer":"alice","receiver":"bob","amount":"10","intervalSeconds":3600,"nextRun":"2020-01-01T00:00:00Z","payments":0,"failures":0,"active":true}`, check: saveId(&scheduleId)},
+
+		{name: "execute without max", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "0"}, err: invalidArgumentCode},
+		{name: "execute first payment", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"}, want: `{"payments":1,"failures":[],"more":false}`, event: transferEventName, check: advance},
+		{name: "execute with too little coins", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"},
+			want: `{"payments":1,"failures":[{"due":"2020-01-01T02:00:00Z"}]}`},
+		{name: "paid", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "bob"}, want: `{"balance":"20"}`},
+		{name: "schedule", caller: test.alice, function: "GetSchedule", argsOf: schedule, want: `{"nextRun":"2020-01-01T03:00:00Z","payments":2,"failures":1,"active":true}`},
+		{name: "failures", caller: test.alice, function: "ScheduleFailures", argsOf: schedule, want: `[{"due":"2020-01-01T02:00:00Z"}]`},
+		{name: "unknown schedule", caller: test.alice, function: "GetSchedule", args: []string{"nope"}, err: notFoundCode},
+
+		{name: "cancel by other user", caller: test.bob, function: "CancelSchedule", argsOf: schedule, err: noPermissionsCode},
+		{name: "cancel", caller: test.alice, function: "CancelSchedule", argsOf: schedule, want: `{"active":false}`, check: advance},
+		{name: "execute cancelled", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"}, want: `{"payments":0,"failures":[]}`},
+
+		{name: "create ended", caller: test.minter, function: "CreateSchedule", args: []string{"", "bob", "1", "3600", "2020-01-01T00:00:00Z", "2020-01-01T01:00:00Z"}, check: saveId(&scheduleId)},
+		{name: "execute until end", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"}, want: `{"payments":2,"failures":[]}`},
+		{name: "ended schedule", caller: test.alice, function: "GetSchedule", argsOf: schedule, want: `{"payments":2,"active":false}`},
+	})
+}
+
+func TestSchedulesBatches(t *testing.T) {
+	test := newCoinsTest(t)
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "50", "", "", ""}},
+		{name: "create first", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "1", "3600", "2020-01-01T00:00:00Z", ""}},
+		{name: "create second", caller: test.alice, function: "CreateSchedule", args: []string{"", "carol", "1", "3600", "2020-01-01T00:00:00Z", ""}},
+		{name: "create later", caller: test.alice, function: "CreateSchedule", args: []string{"", "carol", "1", "3600", "2020-01-02T00:00:00Z", ""}},
+
+		{name: "execute one", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "1"}, want: `{"payments":1,"failures":[],"more":true}`},
+		{name: "execute rest", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "1"}, want: `{"payments":1,"failures":[],"more":false}`},
+		{name: "execute nothing due", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "1"}, want: `{"payments":0,"failures":[],"more":false}`},
+		{name: "paid", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"48"}`},
+	})
+}
+
+func TestSchedulesLimitsAndFees(t *testing.T) {
+	test := newCoinsTest(t)
+	var scheduleId string
+	schedule := func() []string { return []string{scheduleId} }
+	advance := func(t *testing.T, payload []byte) { test.network.advance(2 * time.Hour) }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "pay alice", caller: test.minter, function: "Transfer", args: []string{"", "user_", "alice", "100", "", "", ""}},
+		{name: "create", caller: test.alice, function: "CreateSchedule", args: []string{"", "bob", "10", "3600", "2020-01-01T00:00:00Z", ""}, check: saveId(&scheduleId)},
+		{name: "set limits", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{"dailyCap":"25"}`}, check: advance},
+
+		{name: "execute up to daily cap", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"},
+			want: `{"payments":2,"failures":[{"due":"2020-01-01T02:00:00Z","error":"LIMIT_EXCEEDED: daily cap of 25 exceeded"}]}`},
+		{name: "outflow", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"20"}`},
+		{name: "paid up to daily cap", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "bob"}, want: `{"balance":"20"}`},
+		{name: "remove limits", caller: test.admin, function: "SetDefaultLimits", args: []string{"", `{}`}},
+
+		{name: "set fee", caller: test.admin, function: "SetFeePolicy", args: []string{"", `{"default":{"flat":"1"},"accountTypes":[],"exemptAccountTypes":[]}`}},
+		{name: "freeze receiver", caller: test.admin, function: "FreezeAccount", args: []string{"user_", "bob", "audit"}, check: advance},
+		{name: "execute with frozen receiver", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"},
+			want: `{"payments":0,"failures":[{"due":"2020-01-01T03:00:00Z"},{"due":"2020-01-01T04:00:00Z"}]}`},
+		{name: "nothing paid", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "bob"}, want: `{"balance":"20"}`},
+		{name: "unfreeze receiver", caller: test.admin, function: "UnfreezeAccount", args: []string{"user_", "bob"}, check: advance},
+		{name: "execute with fee", caller: test.carol, function: "ExecuteDueSchedules", args: []string{"", "10"}, want: `{"payments":2,"failures":[]}`},
+		{name: "paid with fee", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"58"}`},
+		{name: "outflow with fees", caller: test.alice, function: "LimitsOf", args: []string{"", "alice"}, want: `{"dailyOutflow":"42"}`},
+		{name: "schedule", caller: test.alice, function: "GetSchedule", argsOf: schedule, want: `{"nextRun":"2020-01-01T07:00:00Z","payments":4,"failures":3}`},
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Error codes returned to clients as "<CODE>: <message>", so applications
+// can map failed transactions to their own statuses.
+var invalidArgumentCode = "INVALID_ARGUMENT"
+var invalidAmountCode = "INVALID_AMOUNT"
+var invalidReceiverCode = "INVALID_RECEIVER"
+var duplicateReceiverCode = "DUPLICATE_RECEIVER"
+var insufficientFundsCode = "INSUFFICIENT_FUNDS"
+var insufficientAllowanceCode = "INSUFFICIENT_ALLOWANCE"
+var invalidIdentityCode = "INVALID_IDENTITY"
+var noPermissionsCode = "NO_PERMISSIONS"
+var notFoundCode = "NOT_FOUND"
+var invalidStateCode = "INVALID_STATE"
+var pausedCode = "PAUSED"
+var accountFrozenCode = "ACCOUNT_FROZEN"
+var duplicateRequestCode = "DUPLICATE_REQUEST"
+var limitExceededCode = "LIMIT_EXCEEDED"
+var slippageCode = "SLIPPAGE"
+
+var maxMemoLength = 256
+var maxReferenceLength = 64
+
+type CoinError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *CoinError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(code string, format string, args ...interface{}) error {
+	return &CoinError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func validateReceiver(receiverAccountType string, receiver string) error {
+	if len(receiverAccountType) == 0 || len(receiver) == 0 {
+		return newError(invalidReceiverCode, "receiver is required")
+	}
+	return nil
+}
+
+func validateMemo(memo string, reference string) error {
+	if len(memo) > maxMemoLength {
+		return newError(invalidArgumentCode, "memo is longer than %d bytes", maxMemoLength)
+	}
+	if len(reference) > maxReferenceLength {
+		return newError(invalidArgumentCode, "reference is longer than %d bytes", maxReferenceLength)
+	}
+	return nil
+}
+
+// validateTransferRequests checks batch entries and returns their amounts in
+// base units together with the total amount.
+func validateTransferRequests(transferRequests []TransferRequest, decimals int) ([]*big.Int, *big.Int, error) {
+	if len(transferRequests) == 0 {
+		return nil, nil, newError(invalidArgumentCode, "no transfer requests")
+	}
+
+	amounts := make([]*big.Int, 0, len(transferRequests))
+	total := new(big.Int)
+	receivers := make(map[string]bool)
+
+	for _, tr := range transferRequests {
+		err := validateReceiver(userAccountType, tr.UserId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if receivers[tr.UserId] {
+			return nil, nil, newError(duplicateReceiverCode, "duplicate receiver %s", tr.UserId)
+		}
+		receivers[tr.UserId] = true
+
+		err = validateMemo(tr.Memo, tr.Reference)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		amount, err := parseAmount(tr.Amount.String(), decimals)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		amounts = append(amounts, amount)
+		total.Add(total, amount)
+	}
+
+	return amounts, total, nil
+}
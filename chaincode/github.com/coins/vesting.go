@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Granted coins are kept on the vesting_<grant id> account until the
+// beneficiary claims them. Grants are stored under
+// grant~<beneficiary id>~<grant id>. Nothing vests before the cliff, then
+// coins vest linearly until start + duration.
+var vestingAccountType = "vesting_"
+var grantPrefix = "grant"
+
+type Grant struct {
+	Id              string `json:"id"`
+	Currency        string `json:"currency"`
+	Beneficiary     string `json:"beneficiary"`
+	Grantor         string `json:"grantor"`
+	Amount          string `json:"amount"`
+	Claimed         string `json:"claimed"`
+	Start           string `json:"start"`
+	CliffSeconds    int64  `json:"cliffSeconds"`
+	DurationSeconds int64  `json:"durationSeconds"`
+	Revoked         bool   `json:"revoked"`
+	Vested          string `json:"vested"`
+	Claimable       string `json:"claimable"`
+}
+
+// CreateGrant moves amount coins of the minter to a vesting grant for the
+// user. The transaction ID is the grant ID.
+func (t *CoinChain) CreateGrant(ctx contractapi.TransactionContextInterface, currency string, beneficiary string, amount string, start string, cliffSeconds int64, durationSeconds int64) (*Grant, error) {
+
+	fmt.Println("grant to " + beneficiary + ", amount " + amount + ", start " + start)
+	fmt.Println("cliff ", cliffSeconds, ", duration ", durationSeconds)
+
+	err := validateReceiver(userAccountType, beneficiary)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return nil, newError(invalidArgumentCode, "incorrect start %s", start)
+	}
+
+	if durationSeconds <= 0 || cliffSeconds < 0 || cliffSeconds > durationSeconds {
+		return nil, newError(invalidArgumentCode, "incorrect cliff %d or duration %d", cliffSeconds, durationSeconds)
+	}
+
+	sheet, err := newBalanceSheet(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUserId, err := checkMinter(ctx, sheet.currency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkReceiver(ctx, userAccountType, beneficiary)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseAmount(amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	grant := &Grant{
+		Id:              ctx.GetStub().GetTxID(),
+		Currency:        sheet.currency.Symbol,
+		Beneficiary:     beneficiary,
+		Grantor:         currentUserId,
+		Amount:          formatAmount(value, sheet.decimals),
+		Claimed:         "0",
+		Start:           startTime.UTC().Format(time.RFC3339Nano),
+		CliffSeconds:    cliffSeconds,
+		DurationSeconds: durationSeconds,
+	}
+
+	grantorAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	vestingAccount, err := ctx.GetStub().CreateCompositeKey(vestingAccountType, []string{grant.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLimits(ctx, sheet, grantorAccount, []*big.Int{value}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(grantorAccount, vestingAccount, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(transferEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveGrant(ctx, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.refreshGrant(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// ClaimVested moves vested and not yet claimed coins of the grant to the
+// current user's account.
+func (t *CoinChain) ClaimVested(ctx contractapi.TransactionContextInterface, grantId string) (*Grant, error) {
+
+	fmt.Println("claim grant " + grantId)
+
+	currentUserId, err := getCurrentUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	grant, err := getGrant(ctx, currentUserId, grantId)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, err := newBalanceSheet(ctx, grant.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	claimable, err := sheet.claimable(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	if claimable.Sign() == 0 {
+		return nil, newError(invalidStateCode, "nothing to claim")
+	}
+
+	vestingAccount, err := ctx.GetStub().CreateCompositeKey(vestingAccountType, []string{grant.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	beneficiaryAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{currentUserId})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.transfer(vestingAccount, beneficiaryAccount, claimable)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.emit(transferEventName)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := parseNonNegativeAmount(grant.Claimed, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+	grant.Claimed = formatAmount(claimed.Add(claimed, claimable), sheet.decimals)
+
+	err = saveGrant(ctx, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.refreshGrant(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// RevokeGrant stops vesting and returns the unvested coins to the current
+// minter of the currency, which is not the grantor after a minter handover.
+// Coins vested before revocation stay claimable.
+func (t *CoinChain) RevokeGrant(ctx contractapi.TransactionContextInterface, beneficiary string, grantId string) (*Grant, error) {
+
+	fmt.Println("revoke grant " + grantId + " of " + beneficiary)
+
+	_, err := checkRole(ctx, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNotPaused(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	grant, err := getGrant(ctx, beneficiary, grantId)
+	if err != nil {
+		return nil, err
+	}
+
+	if grant.Revoked {
+		return nil, newError(invalidStateCode, "grant is already revoked")
+	}
+
+	sheet, err := newBalanceSheet(ctx, grant.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := parseNonNegativeAmount(grant.Amount, sheet.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	vested, err := sheet.vested(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	unvested := new(big.Int).Sub(amount, vested)
+
+	// The grant amount shrinks to the vested part, so the vesting is complete
+	grant.Amount = formatAmount(vested, sheet.decimals)
+	grant.Revoked = true
+
+	if unvested.Sign() > 0 {
+		vestingAccount, err := ctx.GetStub().CreateCompositeKey(vestingAccountType, []string{grant.Id})
+		if err != nil {
+			return nil, err
+		}
+
+		minterAccount, err := ctx.GetStub().CreateCompositeKey(userAccountType, []string{sheet.currency.Minter})
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.transfer(vestingAccount, minterAccount, unvested)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.save()
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.emit(transferEventName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = saveGrant(ctx, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.refreshGrant(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+func (t *CoinChain) GetGrant(ctx contractapi.TransactionContextInterface, beneficiary string, grantId string) (*Grant, error) {
+
+	grant, err := getGrant(ctx, beneficiary, grantId)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, err := newBalanceSheet(ctx, grant.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sheet.refreshGrant(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+func (t *CoinChain) GrantsOf(ctx contractapi.TransactionContextInterface, beneficiary string) ([]*Grant, error) {
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(grantPrefix, []string{beneficiary})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	grants := []*Grant{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		grant := new(Grant)
+		err = json.Unmarshal(entry.Value, grant)
+		if err != nil {
+			return nil, err
+		}
+
+		// Grants of the beneficiary may be in different currencies
+		sheet, err := newBalanceSheet(ctx, grant.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sheet.refreshGrant(grant)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
+}
+
+// vested returns the amount of the grant vested as of the transaction timestamp.
+func (s *balanceSheet) vested(grant *Grant) (*big.Int, error) {
+	amount, err := parseNonNegativeAmount(grant.Amount, s.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	if grant.Revoked {
+		return amount, nil
+	}
+
+	startTime, err := time.Parse(time.RFC3339Nano, grant.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	txTime, err := getTxTime(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := int64(txTime.Sub(startTime) / time.Second)
+
+	if elapsed < grant.CliffSeconds {
+		return new(big.Int), nil
+	}
+
+	if elapsed >= grant.DurationSeconds {
+		return amount, nil
+	}
+
+	vested := new(big.Int).Mul(amount, big.NewInt(elapsed))
+	return vested.Quo(vested, big.NewInt(grant.DurationSeconds)), nil
+}
+
+func (s *balanceSheet) claimable(grant *Grant) (*big.Int, error) {
+	vested, err := s.vested(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := parseNonNegativeAmount(grant.Claimed, s.decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	return vested.Sub(vested, claimed), nil
+}
+
+// refreshGrant sets the vested and claimable amounts of the grant.
+func (s *balanceSheet) refreshGrant(grant *Grant) error {
+	vested, err := s.vested(grant)
+	if err != nil {
+		return err
+	}
+
+	claimable, err := s.claimable(grant)
+	if err != nil {
+		return err
+	}
+
+	grant.Vested = formatAmount(vested, s.decimals)
+	grant.Claimable = formatAmount(claimable, s.decimals)
+	return nil
+}
+
+func getGrant(ctx contractapi.TransactionContextInterface, beneficiary string, grantId string) (*Grant, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(grantPrefix, []string{beneficiary, grantId})
+	if err != nil {
+		return nil, err
+	}
+
+	grantBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(grantBytes) == 0 {
+		return nil, newError(notFoundCode, "grant %s not found", grantId)
+	}
+
+	grant := new(Grant)
+	err = json.Unmarshal(grantBytes, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+func saveGrant(ctx contractapi.TransactionContextInterface, grant *Grant) error {
+	key, err := ctx.GetStub().CreateCompositeKey(grantPrefix, []string{grant.Beneficiary, grant.Id})
+	if err != nil {
+		return err
+	}
+
+	grant.Vested = ""
+	grant.Claimable = ""
+
+	grantBytes, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, grantBytes)
+}
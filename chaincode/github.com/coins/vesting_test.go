@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVesting(t *testing.T) {
+	test := newCoinsTest(t)
+	var grantId string
+	grant := func() []string { return []string{grantId} }
+	alicesGrant := func() []string { return []string{"alice", grantId} }
+	advance := func(duration time.Duration) func(t *testing.T, payload []byte) {
+		return func(t *testing.T, payload []byte) { test.network.advance(duration) }
+	}
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "200"}},
+
+		{name: "create by user", caller: test.alice, function: "CreateGrant", args: []string{"", "bob", "100", "2020-01-01T00:00:00Z", "3600", "14400"}, err: noPermissionsCode},
+		{name: "create with bad start", caller: test.minter, function: "CreateGrant", args: []string{"", "alice", "100", "today", "3600", "14400"}, err: invalidArgumentCode},
+		{name: "create with cliff after end", caller: test.minter, function: "CreateGrant", args: []string{"", "alice", "100", "2020-01-01T00:00:00Z", "20000", "14400"}, err: invalidArgumentCode},
+		{name: "create too much", caller: test.minter, function: "CreateGrant", args: []string{"", "alice", "300", "2020-01-01T00:00:00Z", "3600", "14400"}, err: insufficientFundsCode},
+		{name: "create", caller: test.minter, function: "CreateGrant", args: []string{"", "alice", "100", "2020-01-01T00:00:00Z", "3600", "14400"},
+			want: `{"beneficiary":"alice","grantor":"sj_coin","amount":"100","claimed":"0","cliffSeconds":3600,"durationSeconds":14400,"revoked":false,"vested":"0","claimable":"0"}`, event: transferEventName, check: saveId(&grantId)},
+		{name: "claim before cliff", caller: test.alice, function: "ClaimVested", argsOf: grant, err: invalidStateCode},
+		{name: "grant before cliff", caller: test.bob, function: "GetGrant", argsOf: alicesGrant, want: `{"vested":"0"}`, check: advance(2 * time.Hour)},
+		{name: "grant half vested", caller: test.bob, function: "GetGrant", argsOf: alicesGrant, want: `{"vested":"50","claimable":"50"}`},
+		{name: "claim of other user", caller: test.bob, function: "ClaimVested", argsOf: grant, err: notFoundCode},
+		{name: "claim", caller: test.alice, function: "ClaimVested", argsOf: grant, want: `{"claimed":"50","claimable":"0"}`, event: transferEventName},
+		{name: "claim nothing", caller: test.alice, function: "ClaimVested", argsOf: grant, err: invalidStateCode},
+		{name: "claimed balance", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "alice"}, want: `{"balance":"50"}`, check: advance(time.Hour)},
+
+		{name: "revoke by user", caller: test.alice, function: "RevokeGrant", argsOf: alicesGrant, err: noPermissionsCode},
+		{name: "revoke", caller: test.admin, function: "RevokeGrant", argsOf: alicesGrant, want: `{"amount":"75","revoked":true,"vested":"75","claimable":"25"}`, event: transferEventName},
+		{name: "revoke again", caller: test.admin, function: "RevokeGrant", argsOf: alicesGrant, err: invalidStateCode},
+		{name: "unvested returned", caller: test.alice, function: "BalanceOf", args: []string{"", "user_", "sj_coin"}, want: `{"balance":"125"}`, check: advance(time.Hour)},
+		{name: "claim after revoke", caller: test.alice, function: "ClaimVested", argsOf: grant, want: `{"claimed":"75","claimable":"0"}`},
+		{name: "grants", caller: test.bob, function: "GrantsOf", args: []string{"alice"}, want: `[{"amount":"75","claimed":"75","revoked":true}]`},
+		{name: "no grants", caller: test.bob, function: "GrantsOf", args: []string{"bob"}, want: `[]`},
+		{name: "unknown grant", caller: test.bob, function: "GetGrant", args: []string{"alice", "nope"}, err: notFoundCode},
+	})
+}
+
+func TestRevokeAfterMinterHandover(t *testing.T) {
+	test := newCoinsTest(t)
+	var grantId string
+	alicesGrant := func() []string { return []string{"alice", grantId} }
+
+	test.run(t, []txCase{
+		{name: "mint", caller: test.minter, function: "Mint", args: []string{"", "100"}},
+		{name: "create", caller: test.minter, function: "CreateGrant", args: []string{"", "alice", "100", "2020-01-01T00:00:00Z", "3600", "14400"}, check: saveId(&grantId)},
+		{name: "transfer minter", caller: test.minter, function: "TransferMinter", args: []string{"carol"}},
+		{name: "accept minter", caller: test.carol, function: "AcceptMinter"},
+		{name: "revoke", caller: test.admin, function: "RevokeGrant", argsOf: alicesGrant, want: `{"grantor":"sj_coin","amount":"0","revoked":true}`},
+		{name: "returned to new minter", caller: test.carol, function: "BalanceOf", args: []string{"", "user_", "carol"}, want: `{"balance":"100"}`},
+		{name: "not returned to old minter", caller: test.carol, function: "BalanceOf", args: []string{"", "user_", "sj_coin"}, want: `{"balance":"0"}`},
+	})
+}
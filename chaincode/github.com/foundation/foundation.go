@@ -1,25 +1,28 @@
 package main
 
 import (
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/common/util"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/helper"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"log"
+	"math/big"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var logger *shim.ChaincodeLogger
+var logger = log.New(os.Stdout, "foundations ", log.LstdFlags)
 
 type FoundationChain struct {
 }
 
 type WithdrawDetails struct {
-	Amount uint      `json:"amount"`
+	Amount string    `json:"amount"`
 	Id     uint      `json:"id"`
 	Time   time.Time `json:"time"`
 	Note   string    `json:"note"`
@@ -29,53 +32,81 @@ type Donation struct {
 	UserId          string `json:"userId"`
 	UserAccountType string `json:"userAccountType"`
 	Currency        string `json:"currency"`
-	Amount          uint   `json:"amount"`
+	Amount          string `json:"amount"`
 }
 
 type Foundation struct {
 	Name               string                  `json:"name"`               //Foundation name
 	CreatorId          string                  `json:"creatorId"`          //Foundation founder ID
 	AdminID            string                  `json:"adminId"`            //Foundation admin ID
-	FundingGoal        uint                    `json:"fundingGoal"`        //Amount of coins to collect
-	CollectedAmount    uint                    `json:"collectedAmount"`    //Amount of coins which were collected before contract has been closed
-	ContractRemains    uint                    `json:"contractRemains"`    //Amount of coins which were collected after contract has been closed
+	FundingGoal        string                  `json:"fundingGoal"`        //Amount of coins to collect
+	CollectedAmount    string                  `json:"collectedAmount"`    //Amount of coins which were collected before contract has been closed
+	ContractRemains    string                  `json:"contractRemains"`    //Amount of coins which were collected after contract has been closed
 	MainCurrency       string                  `json:"mainCurrency"`       //Currency into which should be exchanged all other currencies
 	Deadline           time.Time               `json:"deadline"`           //Contract's deadline(timestamp)
 	CloseOnGoalReached bool                    `json:"closeOnGoalReached"` //Condition of contract closing
 	AcceptCurrencies   map[string]bool         `json:"acceptCurrencies"`   //Array of currencies which are allowed for contract
-	DonationsMapOld    map[string]uint         `json:"donationsMapOld"`    //Map with donation info
+	DonationsMapOld    map[string]string       `json:"donationsMapOld"`    //Map with donation info
 	DonationsMap       map[int]Donation        `json:"donationsMap"`       //Map with donation info
 	WithdrawDetailsMap map[int]WithdrawDetails `json:"withdrawDetailsMap"` //Map with withdraw info
-	WithdrawalAllowed  bool                    `json: withdrawAllowed`
+	WithdrawalAllowed  bool                    `json:"withdrawalAllowed"`
 	FundingGoalReached bool                    `json:"fundingGoalReached"`
 	IsContractClosed   bool                    `json:"isContractClosed"`
 	IsDonationReturned bool                    `json:"isDonationReturned"`
-	AllowanceMap       map[string]uint         `json:"allowanceMap"` //Map with allowance info
+	AllowanceMap       map[string]string       `json:"allowanceMap"` //Map with allowance info
 }
 
 var channelName string = "mychannel"
+
+// Refunds and withdrawals are paid by TransferFrom on behalf of the foundation
+// admin, so a coins admin must first allow the admin to spend the foundation
+// account: ApproveFor(currency, "foundation_", name, "user_", adminId, amount).
+// Coins method names are case sensitive.
+var coinsChaincode string = "coins" // hosts every currency, see CreateCurrency
 var foundationAccountType string = "foundation_"
 var userAccountType string = "user_"
 var foundationsKey string = "foundations"
+var homeMspKey string = "homeMsp"
+var userIdAttribute string = "coins.userId"
+var mspSeparator string = "::"
 
 func main() {
 	err := shim.Start(new(FoundationChain))
 	if err != nil {
-		logger.Errorf("Error starting Foundation chaincode: %s", err)
+		logger.Printf("Error starting Foundation chaincode: %s", err)
 	}
 }
 
 func (t *FoundationChain) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 	//_, args := stub.GetFunctionAndParameters()
-	logger = shim.NewLogger(foundationsKey)
-	logger.Infof("######### %v Init ########", foundationsKey)
+	logger.Printf("######### %v Init ########", foundationsKey)
 
 	mapBytes, err := stub.GetState(foundationsKey)
 	if err != nil {
-		logger.Info("Init get foundations error: ", err)
+		logger.Println("Init get foundations error: ", err)
+	}
+	logger.Printf("Init foundations map %s: ", mapBytes)
+
+	// Users of this MSP are identified by their user ID only, see
+	// getCurrentUserId. Upgrades keep the MSP of the first instantiation, so
+	// user IDs do not change.
+	homeMspBytes, err := stub.GetState(homeMspKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(homeMspBytes) == 0 {
+		mspId, err := cid.GetMSPID(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = stub.PutState(homeMspKey, []byte(mspId))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
-	logger.Infof("Init foundations map %s: ", mapBytes)
 
 	if len(mapBytes) == 0 {
 		foundationsMap := make(map[string]Foundation)
@@ -145,20 +176,22 @@ func (t *FoundationChain) createFoundation(stub shim.ChaincodeStubInterface, arg
 
 	foundation := Foundation{}
 	foundation.Name = args[0]
-	logger.Info("foundationName: ", foundation.Name)
+	logger.Println("foundationName: ", foundation.Name)
 
 	foundation.AdminID = args[1]
-	logger.Info("admin ID: ", foundation.AdminID)
+	logger.Println("admin ID: ", foundation.AdminID)
 
 	foundation.CreatorId = args[2]
-	logger.Info("creator ID: ", foundation.CreatorId)
+	logger.Println("creator ID: ", foundation.CreatorId)
 
-	fundingGoalArg, err := strconv.ParseUint(args[3], 10, 32)
+	fundingGoal, err := parseAmount(args[3])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	foundation.FundingGoal = uint(fundingGoalArg)
-	logger.Info("funding Goal: ", foundation.FundingGoal)
+	foundation.FundingGoal = formatAmount(fundingGoal)
+	foundation.CollectedAmount = "0"
+	foundation.ContractRemains = "0"
+	logger.Println("funding Goal: ", foundation.FundingGoal)
 
 	minutesInt, err := strconv.ParseInt(args[4], 10, 32)
 	if err != nil {
@@ -167,7 +200,7 @@ func (t *FoundationChain) createFoundation(stub shim.ChaincodeStubInterface, arg
 	duration := time.Minute * time.Duration(minutesInt)
 	currentTime := time.Now()
 	foundation.Deadline = currentTime.Add(duration)
-	logger.Info("deadline: ", foundation.Deadline.Format(time.RFC3339))
+	logger.Println("deadline: ", foundation.Deadline.Format(time.RFC3339))
 
 	closeOnGoal, err := strconv.ParseBool(args[5])
 	if err != nil {
@@ -175,7 +208,7 @@ func (t *FoundationChain) createFoundation(stub shim.ChaincodeStubInterface, arg
 	}
 
 	foundation.CloseOnGoalReached = closeOnGoal
-	logger.Info("closeOnGoalReached: ", foundation.CloseOnGoalReached)
+	logger.Println("closeOnGoalReached: ", foundation.CloseOnGoalReached)
 
 	withdrawalAllowed, err := strconv.ParseBool(args[6])
 	if err != nil {
@@ -185,21 +218,21 @@ func (t *FoundationChain) createFoundation(stub shim.ChaincodeStubInterface, arg
 	foundation.WithdrawalAllowed = withdrawalAllowed
 
 	foundation.MainCurrency = args[7]
-	logger.Info("Main currency: ", foundation.MainCurrency)
+	logger.Println("Main currency: ", foundation.MainCurrency)
 
 	currencies := args[8:]
-	logger.Info("currencies: ", currencies)
+	logger.Println("currencies: ", currencies)
 
 	foundation.AcceptCurrencies = make(map[string]bool)
 	for _, v := range currencies {
 		foundation.AcceptCurrencies[v] = true
 	}
-	logger.Info("Accept Currencies: ", foundation.AcceptCurrencies)
+	logger.Println("Accept Currencies: ", foundation.AcceptCurrencies)
 
-	foundation.DonationsMapOld = make(map[string]uint)
+	foundation.DonationsMapOld = make(map[string]string)
 	foundation.DonationsMap = make(map[int]Donation)
 	foundation.WithdrawDetailsMap = make(map[int]WithdrawDetails)
-	foundation.AllowanceMap = make(map[string]uint)
+	foundation.AllowanceMap = make(map[string]string)
 	foundations[foundation.Name] = foundation
 	err = saveFoundations(stub, foundations)
 	if err != nil {
@@ -212,7 +245,7 @@ func (t *FoundationChain) createFoundation(stub shim.ChaincodeStubInterface, arg
 func (t *FoundationChain) donate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	/* args
-	0 - currency name (docker container name - coin)
+	0 - currency symbol
 	1 - amount
 	2 - foundation name
 	*/
@@ -236,24 +269,27 @@ func (t *FoundationChain) donate(stub shim.ChaincodeStubInterface, args []string
 	}
 
 	currency := args[0]
-	logger.Info("Currency (chaincode) Name: ", currency)
+	logger.Println("Currency: ", currency)
 
-	logger.Info("acceptCurrencies ", foundation.AcceptCurrencies)
+	logger.Println("acceptCurrencies ", foundation.AcceptCurrencies)
 	if !foundation.AcceptCurrencies[currency] {
 		return shim.Error("Can not accept currency " + currency)
 	}
 
-	amount := t.parseAmountUint(args[1])
-	logger.Info("amount: ", amount)
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	logger.Println("amount: ", amount)
 
-	if amount == 0 {
+	if amount.Sign() == 0 {
 		return shim.Error("Error. Amount must be > 0")
 	}
 
-	logger.Info("Invoke Transfer method on: ", currency)
-	queryArgs := util.ToChaincodeArgs("transfer", foundationAccountType, foundation.Name, args[1])
-	response := stub.InvokeChaincode(currency, queryArgs, channelName)
-	logger.Info("Transfer Response status: ", response.Status)
+	logger.Println("Invoke Transfer method of: ", currency)
+	queryArgs := toChaincodeArgs("Transfer", currency, foundationAccountType, foundation.Name, args[1], "", "Donation", "")
+	response := stub.InvokeChaincode(coinsChaincode, queryArgs, channelName)
+	logger.Println("Transfer Response status: ", response.Status)
 
 	if response.Status == shim.OK {
 
@@ -266,7 +302,7 @@ func (t *FoundationChain) donate(stub shim.ChaincodeStubInterface, args []string
 			UserId:          currentUserId,
 			UserAccountType: userAccountType,
 			Currency:        currency,
-			Amount:          amount,
+			Amount:          formatAmount(amount),
 		}
 
 		foundation.DonationsMap[len(foundation.DonationsMap)+1] = donation
@@ -276,9 +312,9 @@ func (t *FoundationChain) donate(stub shim.ChaincodeStubInterface, args []string
 			return shim.Error(err.Error())
 		}
 
-		foundation.DonationsMapOld[donationKey] += amount
-		foundation.CollectedAmount += amount
-		logger.Info(foundation.Name, " - foundation.CollectedAmount ", foundation.CollectedAmount)
+		foundation.DonationsMapOld[donationKey] = formatAmount(new(big.Int).Add(amountOf(foundation.DonationsMapOld[donationKey]), amount))
+		foundation.CollectedAmount = formatAmount(new(big.Int).Add(amountOf(foundation.CollectedAmount), amount))
+		logger.Println(foundation.Name, " - foundation.CollectedAmount ", foundation.CollectedAmount)
 
 		checkGoalReached(&foundation)
 
@@ -288,7 +324,7 @@ func (t *FoundationChain) donate(stub shim.ChaincodeStubInterface, args []string
 			return shim.Error(err.Error())
 		}
 
-		return shim.Success([]byte(strconv.FormatUint(uint64(foundation.CollectedAmount), 10)))
+		return shim.Success([]byte(foundation.CollectedAmount))
 	} else {
 		return shim.Error(response.Message)
 	}
@@ -304,7 +340,7 @@ func (t *FoundationChain) closeFoundation(stub shim.ChaincodeStubInterface, args
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
-	logger.Info("Foundation name: ", args[0])
+	logger.Println("Foundation name: ", args[0])
 
 	foundations, err := getFoundations(stub)
 	if err != nil {
@@ -334,7 +370,7 @@ func (t *FoundationChain) closeFoundation(stub shim.ChaincodeStubInterface, args
 	//TODO Define Return donations flow
 	if foundation.FundingGoalReached {
 		foundation.ContractRemains = foundation.CollectedAmount
-		logger.Info(foundation.Name, " - Contract Remains: ", foundation.ContractRemains)
+		logger.Println(foundation.Name, " - Contract Remains: ", foundation.ContractRemains)
 	}
 
 	if !foundation.FundingGoalReached {
@@ -342,29 +378,30 @@ func (t *FoundationChain) closeFoundation(stub shim.ChaincodeStubInterface, args
 
 			// Old map
 			for k, v := range foundation.DonationsMapOld {
-				if v > 0 {
+				if amountOf(v).Sign() > 0 {
 					currency, parts, err := stub.SplitCompositeKey(k)
-					logger.Info("Key : ", k)
-					logger.Info("currency: ", currency)
-					logger.Info("parts: ", parts)
-					logger.Info("amount value v: ", v)
+					logger.Println("Key : ", k)
+					logger.Println("currency: ", currency)
+					logger.Println("parts: ", parts)
+					logger.Println("amount value v: ", v)
 
 					if err != nil {
 						return shim.Error(err.Error())
 					}
 
 					/* transferFrom args
-					0 - sender account type (user_ , foundation_)
-					1 - sender ID
-					2 - receiver account type (user_ , foundation_)
-					3 - receiver ID
-					4 - amount
+					0 - currency symbol
+					1 - sender account type (user_ , foundation_)
+					2 - sender ID
+					3 - receiver account type (user_ , foundation_)
+					4 - receiver ID
+					5 - amount
 					*/
 
-					logger.Info("Invoke transferFrom method on: ", currency)
-					queryArgs := util.ToChaincodeArgs("transferFrom", foundationAccountType, foundation.Name, userAccountType, parts[1], strconv.FormatUint(uint64(v), 10))
-					response := stub.InvokeChaincode(currency, queryArgs, channelName)
-					logger.Info("Response status: ", response.Status)
+					logger.Println("Invoke TransferFrom method of: ", currency)
+					queryArgs := toChaincodeArgs("TransferFrom", currency, foundationAccountType, foundation.Name, userAccountType, parts[1], v)
+					response := stub.InvokeChaincode(coinsChaincode, queryArgs, channelName)
+					logger.Println("Response status: ", response.Status)
 
 					if response.Status != shim.OK {
 						return shim.Error(response.Message)
@@ -376,7 +413,7 @@ func (t *FoundationChain) closeFoundation(stub shim.ChaincodeStubInterface, args
 		}
 	} else {
 		foundation.ContractRemains = foundation.CollectedAmount
-		logger.Info(foundation.Name, " - Contract Remains: ", foundation.ContractRemains)
+		logger.Println(foundation.Name, " - Contract Remains: ", foundation.ContractRemains)
 	}
 
 	foundation.IsContractClosed = true
@@ -386,7 +423,7 @@ func (t *FoundationChain) closeFoundation(stub shim.ChaincodeStubInterface, args
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success([]byte(strconv.FormatUint(uint64(foundation.ContractRemains), 10)))
+	return shim.Success([]byte(foundation.ContractRemains))
 }
 
 func (t *FoundationChain) withdraw(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -417,17 +454,20 @@ func (t *FoundationChain) withdraw(stub shim.ChaincodeStubInterface, args []stri
 		return shim.Error("Foundation does not exist.")
 	}
 
-	amount := t.parseAmountUint(amountString)
-	logger.Info("amount: ", amount)
-	logger.Info("note: ", note)
-	logger.Info("contractRemains: ", foundation.ContractRemains)
+	amount, err := parseAmount(amountString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	logger.Println("amount: ", amount)
+	logger.Println("note: ", note)
+	logger.Println("contractRemains: ", foundation.ContractRemains)
 
 	currentUserId, err := getCurrentUserId(stub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	if !foundation.WithdrawalAllowed || foundation.AllowanceMap[currentUserId] < amount {
+	if !foundation.WithdrawalAllowed || amountOf(foundation.AllowanceMap[currentUserId]).Cmp(amount) < 0 {
 		return shim.Error("withdrawal not allowed")
 	}
 
@@ -435,32 +475,33 @@ func (t *FoundationChain) withdraw(stub shim.ChaincodeStubInterface, args []stri
 		return shim.Error("contract is not closed")
 	}
 
-	if amount > foundation.ContractRemains {
+	if amount.Cmp(amountOf(foundation.ContractRemains)) > 0 {
 		return shim.Error("not enough funds")
 	}
 
 	/* transferFrom args
-	0 - sender account type (user_ , foundation_)
-	1 - sender ID
-	2 - receiver account type (user_ , foundation_)
-	3 - receiver ID
-	4 - amount
+	0 - currency symbol
+	1 - sender account type (user_ , foundation_)
+	2 - sender ID
+	3 - receiver account type (user_ , foundation_)
+	4 - receiver ID
+	5 - amount
 	*/
 
-	logger.Info("Invoke transferFrom method on: ", foundation.MainCurrency)
-	queryArgs := util.ToChaincodeArgs("transferFrom", foundationAccountType, foundation.Name, userAccountType, receiverId, strconv.FormatUint(uint64(amount), 10))
-	response := stub.InvokeChaincode(foundation.MainCurrency, queryArgs, channelName)
-	logger.Info("Response status: ", response.Status)
+	logger.Println("Invoke TransferFrom method of: ", foundation.MainCurrency)
+	queryArgs := toChaincodeArgs("TransferFrom", foundation.MainCurrency, foundationAccountType, foundation.Name, userAccountType, receiverId, formatAmount(amount))
+	response := stub.InvokeChaincode(coinsChaincode, queryArgs, channelName)
+	logger.Println("Response status: ", response.Status)
 
 	if response.Status != shim.OK {
 		return shim.Error(response.Message)
 	}
 
-	foundation.ContractRemains -= amount
+	foundation.ContractRemains = formatAmount(new(big.Int).Sub(amountOf(foundation.ContractRemains), amount))
 
-	newDetail := WithdrawDetails{Time: time.Now(), Amount: amount, Note: note, Id: uint(len(foundation.WithdrawDetailsMap) + 1)}
+	newDetail := WithdrawDetails{Time: time.Now(), Amount: formatAmount(amount), Note: note, Id: uint(len(foundation.WithdrawDetailsMap) + 1)}
 	foundation.WithdrawDetailsMap[len(foundation.WithdrawDetailsMap)+1] = newDetail
-	logger.Info("detailsMap: ", foundation.WithdrawDetailsMap)
+	logger.Println("detailsMap: ", foundation.WithdrawDetailsMap)
 
 	foundations[foundation.Name] = foundation
 	err = saveFoundations(stub, foundations)
@@ -468,7 +509,7 @@ func (t *FoundationChain) withdraw(stub shim.ChaincodeStubInterface, args []stri
 		return shim.Error(err.Error())
 	}
 
-	logger.Info("---- withdraw successful")
+	logger.Println("---- withdraw successful")
 	return shim.Success(nil)
 }
 
@@ -528,7 +569,10 @@ func (t *FoundationChain) setAllowance(stub shim.ChaincodeStubInterface, args []
 	foundationName := args[0]
 	userId := args[1]
 	amountString := args[2]
-	amount := t.parseAmountUint(amountString)
+	amount, err := parseAmount(amountString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	foundations, err := getFoundations(stub)
 	if err != nil {
@@ -553,7 +597,7 @@ func (t *FoundationChain) setAllowance(stub shim.ChaincodeStubInterface, args []
 
 		//foundation.AllowanceMap[userAccount] = amount
 
-		foundation.AllowanceMap[userId] = amount
+		foundation.AllowanceMap[userId] = formatAmount(amount)
 		foundations[foundation.Name] = foundation
 		saveFoundations(stub, foundations)
 		return shim.Success(nil)
@@ -568,60 +612,105 @@ func (t *FoundationChain) setAllowance(stub shim.ChaincodeStubInterface, args []
 //
 //	queryArgs := util.ToChaincodeArgs("balanceOf", "Jim")
 //	response := stub.InvokeChaincode("coin", queryArgs, channelName)
-//	logger.Info("Transfer Response status: ", response.Status)
+//	logger.Println("Transfer Response status: ", response.Status)
 //
 //	return shim.Success(nil)
 //}
 
 func checkGoalReached(foundation *Foundation) bool {
 
-	if foundation.CollectedAmount >= foundation.FundingGoal {
+	goalReached := amountOf(foundation.CollectedAmount).Cmp(amountOf(foundation.FundingGoal)) >= 0
+
+	if goalReached {
 		foundation.FundingGoalReached = true
 	}
 
 	if foundation.CloseOnGoalReached {
-		if foundation.CollectedAmount >= foundation.FundingGoal || time.Now().After(foundation.Deadline) {
+		if goalReached || time.Now().After(foundation.Deadline) {
 			foundation.ContractRemains = foundation.CollectedAmount
 			foundation.IsContractClosed = true
 		}
 	}
 
-	logger.Info(foundation.Name, " - FundingGoalReached: ", foundation.FundingGoalReached)
-	logger.Info(foundation.Name, " -   isContractClosed: ", foundation.IsContractClosed)
+	logger.Println(foundation.Name, " - FundingGoalReached: ", foundation.FundingGoalReached)
+	logger.Println(foundation.Name, " -   isContractClosed: ", foundation.IsContractClosed)
 
 	return foundation.FundingGoalReached
 }
 
+// getCurrentUserId resolves the caller the same way the coins chaincode does:
+// the coins.userId enrollment attribute or the certificate common name,
+// prefixed with the MSP ID for users of other organizations than the one
+// the chaincode was instantiated by.
 func getCurrentUserId(stub shim.ChaincodeStubInterface) (string, error) {
 
-	var userId string
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller MSP ID: %s", err.Error())
+	}
 
-	creatorBytes, err := stub.GetCreator()
+	userId, found, err := cid.GetAttributeValue(stub, userIdAttribute)
 	if err != nil {
-		return userId, err
+		return "", fmt.Errorf("failed to get caller attributes: %s", err.Error())
 	}
 
-	creatorString := fmt.Sprintf("%s", creatorBytes)
-	index := strings.Index(creatorString, "-----BEGIN CERTIFICATE-----")
-	certificate := creatorString[index:]
-	block, _ := pem.Decode([]byte(certificate))
+	if !found {
+		cert, err := cid.GetX509Certificate(stub)
+		if err != nil {
+			return "", fmt.Errorf("failed to get caller certificate: %s", err.Error())
+		}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+		if cert == nil {
+			return "", errors.New("caller is not identified by an X509 certificate")
+		}
+
+		userId = cert.Subject.CommonName
+	}
+
+	if len(userId) == 0 || strings.Contains(userId, mspSeparator) {
+		return "", fmt.Errorf("malformed caller identity %q", userId)
+	}
+
+	homeMspBytes, err := stub.GetState(homeMspKey)
 	if err != nil {
-		return userId, err
+		return "", err
+	}
+
+	if mspId != string(homeMspBytes) {
+		userId = mspId + mspSeparator + userId
 	}
 
-	userId = cert.Subject.CommonName
-	logger.Infof("---- Current User ID: %v ", userId)
-	return userId, err
+	logger.Printf("---- Current User ID: %v ", userId)
+	return userId, nil
+}
+
+// Foundation amounts are decimal strings with up to helper.MaxDecimals
+// fractional digits, so they can hold amounts of any accepted currency.
+func parseAmount(amount string) (*big.Int, error) {
+	return helper.ParseAmount(amount, helper.MaxDecimals)
 }
 
-func (t *FoundationChain) parseAmountUint(amount string) uint {
-	amount32, err := strconv.ParseUint(amount, 10, 32)
+func formatAmount(amount *big.Int) string {
+	return helper.FormatAmount(amount, helper.MaxDecimals)
+}
+
+// amountOf returns a stored amount in base units, zero if it is not set.
+func amountOf(amount string) *big.Int {
+	value, err := parseAmount(amount)
 	if err != nil {
-		return 0
+		return new(big.Int)
+	}
+	return value
+}
+
+// toChaincodeArgs converts the function name and arguments of a chaincode
+// call to the byte arguments of InvokeChaincode.
+func toChaincodeArgs(args ...string) [][]byte {
+	byteArgs := make([][]byte, len(args))
+	for i, arg := range args {
+		byteArgs[i] = []byte(arg)
 	}
-	return uint(amount32)
+	return byteArgs
 }
 
 func (t *FoundationChain) receiveApproval(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -630,7 +719,7 @@ func (t *FoundationChain) receiveApproval(stub shim.ChaincodeStubInterface, args
 
 func getFoundations(stub shim.ChaincodeStubInterface) (map[string]Foundation, error) {
 
-	logger.Info("------ getFoundations called")
+	logger.Println("------ getFoundations called")
 	mapBytes, err := stub.GetState(foundationsKey)
 	if err != nil {
 		return nil, err
@@ -641,12 +730,12 @@ func getFoundations(stub shim.ChaincodeStubInterface) (map[string]Foundation, er
 	if err != nil {
 		return nil, err
 	}
-	logger.Info("received Foundations map %s", mapObject)
+	logger.Printf("received Foundations map %v", mapObject)
 	return mapObject, nil
 }
 
 func saveFoundations(stub shim.ChaincodeStubInterface, mapObject map[string]Foundation) error {
-	logger.Info("------ saveFoundations called")
+	logger.Println("------ saveFoundations called")
 
 	mapBytes, err := json.Marshal(mapObject)
 	if err != nil {
@@ -656,6 +745,6 @@ func saveFoundations(stub shim.ChaincodeStubInterface, mapObject map[string]Foun
 	if err != nil {
 		return err
 	}
-	logger.Info("saved ", mapObject)
+	logger.Println("saved ", mapObject)
 	return nil
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+var homeMsp = "Org1MSP"
+
+// coinsMock records the calls of the coins chaincode. Method names are case
+// sensitive, as in the coins chaincode.
+type coinsMock struct {
+	calls [][]string
+	err   string // error of the next call, empty for success
+}
+
+func (c *coinsMock) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *coinsMock) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function != "Transfer" && function != "TransferFrom" {
+		return shim.Error("NOT_FOUND: unknown method " + function)
+	}
+
+	if len(c.err) != 0 {
+		message := c.err
+		c.err = ""
+		return shim.Error(message)
+	}
+
+	c.calls = append(c.calls, append([]string{function}, args...))
+	return shim.Success([]byte(`{"balance":"0"}`))
+}
+
+type foundationCase struct {
+	name     string
+	caller   []byte
+	args     []string
+	coinsErr string   // error returned by the coins chaincode
+	err      string   // expected error message prefix, empty for success
+	want     string   // expected payload, checked if not empty
+	coins    []string // expected call of the coins chaincode, nil for none
+}
+
+func TestFoundations(t *testing.T) {
+	fadmin := newIdentity(t, homeMsp, "fadmin")
+	alice := newIdentity(t, homeMsp, "alice")
+	partner := newIdentity(t, "Org2MSP", "alice")
+
+	coins := new(coinsMock)
+
+	stub := shimtest.NewMockStub("foundation", new(FoundationChain))
+	stub.MockPeerChaincode(coinsChaincode, shimtest.NewMockStub(coinsChaincode, coins), channelName)
+	stub.Creator = fadmin
+
+	response := stub.MockInit("init", [][]byte{[]byte("init")})
+	if response.Status != shim.OK {
+		t.Fatalf("init failed: %s", response.Message)
+	}
+
+	cases := []foundationCase{
+		{name: "create with too few arguments", caller: fadmin, args: []string{"createFoundation", "f1"}, err: "Incorrect number of arguments"},
+		{name: "create", caller: fadmin, args: []string{"createFoundation", "f1", "fadmin", "creator", "100", "60", "false", "true", "SJ", "SJ"}},
+		{name: "create again", caller: fadmin, args: []string{"createFoundation", "f1", "fadmin", "creator", "100", "60", "false", "true", "SJ", "SJ"}, err: "Foundation already exists."},
+		{name: "create with goal", caller: fadmin, args: []string{"createFoundation", "f2", "fadmin", "creator", "10", "60", "false", "true", "SJ", "SJ"}},
+
+		{name: "donate", caller: alice, args: []string{"donate", "SJ", "30", "f1"}, want: "30",
+			coins: []string{"Transfer", "SJ", "foundation_", "f1", "30", "", "Donation", ""}},
+		{name: "donate by partner", caller: partner, args: []string{"donate", "SJ", "5", "f1"}, want: "35",
+			coins: []string{"Transfer", "SJ", "foundation_", "f1", "5", "", "Donation", ""}},
+		{name: "donate other currency", caller: alice, args: []string{"donate", "EUR", "5", "f1"}, err: "Can not accept currency EUR"},
+		{name: "donate nothing", caller: alice, args: []string{"donate", "SJ", "0", "f1"}, err: "Error. Amount must be > 0"},
+		{name: "donate to unknown foundation", caller: alice, args: []string{"donate", "SJ", "5", "nope"}, err: "Foundation does not exist."},
+		{name: "donate without coins", caller: alice, args: []string{"donate", "SJ", "500", "f1"}, coinsErr: "INSUFFICIENT_FUNDS: not enough coins", err: "INSUFFICIENT_FUNDS"},
+
+		{name: "close by user", caller: alice, args: []string{"close", "f1"}, err: "Failed. Only admin can close foundation."},
+		{name: "close without allowance", caller: fadmin, args: []string{"close", "f1"}, coinsErr: "INSUFFICIENT_ALLOWANCE: not enough allowance", err: "INSUFFICIENT_ALLOWANCE"},
+		{name: "close and refund", caller: fadmin, args: []string{"close", "f1"}, want: "0"},
+		{name: "close again", caller: fadmin, args: []string{"close", "f1"}, err: "Failed. Foundation is already closed."},
+		{name: "donate to closed foundation", caller: alice, args: []string{"donate", "SJ", "5", "f1"}, err: "Foundation is closed."},
+
+		{name: "donate to goal", caller: alice, args: []string{"donate", "SJ", "20", "f2"}, want: "20",
+			coins: []string{"Transfer", "SJ", "foundation_", "f2", "20", "", "Donation", ""}},
+		{name: "withdraw before close", caller: fadmin, args: []string{"withdraw", "f2", "bob", "5", "rent"}, err: "withdrawal not allowed"},
+		{name: "close reached goal", caller: fadmin, args: []string{"close", "f2"}, want: "20"},
+		{name: "allow by user", caller: alice, args: []string{"setAllowance", "f2", "alice", "15"}, err: "Failed to set allowance"},
+		{name: "allow", caller: fadmin, args: []string{"setAllowance", "f2", "fadmin", "15"}},
+		{name: "withdraw beyond allowance", caller: fadmin, args: []string{"withdraw", "f2", "bob", "16", "rent"}, err: "withdrawal not allowed"},
+		{name: "withdraw by user", caller: alice, args: []string{"withdraw", "f2", "bob", "5", "rent"}, err: "withdrawal not allowed"},
+		{name: "withdraw", caller: fadmin, args: []string{"withdraw", "f2", "bob", "12.5", "rent"},
+			coins: []string{"TransferFrom", "SJ", "foundation_", "f2", "user_", "bob", "12.5"}},
+		{name: "withdraw beyond remains", caller: fadmin, args: []string{"withdraw", "f2", "bob", "10", "rent"}, err: "not enough funds"},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stub.Creator = tc.caller
+			coins.err = tc.coinsErr
+			calls := len(coins.calls)
+
+			args := make([][]byte, len(tc.args))
+			for j, arg := range tc.args {
+				args[j] = []byte(arg)
+			}
+
+			response := stub.MockInvoke(fmt.Sprintf("tx%d", i), args)
+
+			if len(tc.err) != 0 {
+				if response.Status == shim.OK {
+					t.Fatalf("expected error %q, got success", tc.err)
+				}
+				if !strings.HasPrefix(response.Message, tc.err) {
+					t.Fatalf("expected error %q, got %q", tc.err, response.Message)
+				}
+				return
+			}
+
+			if response.Status != shim.OK {
+				t.Fatalf("%s failed: %s", tc.args[0], response.Message)
+			}
+
+			if len(tc.want) != 0 && string(response.Payload) != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, response.Payload)
+			}
+
+			if tc.coins != nil {
+				if len(coins.calls) != calls+1 {
+					t.Fatalf("expected one coins call, got %d", len(coins.calls)-calls)
+				}
+				if got := coins.calls[calls]; !reflect.DeepEqual(got, tc.coins) {
+					t.Fatalf("expected coins call %v, got %v", tc.coins, got)
+				}
+			}
+		})
+	}
+}
+
+// The refund of a foundation which missed its goal pays every donor back
+// from the foundation account.
+func TestFoundationRefund(t *testing.T) {
+	fadmin := newIdentity(t, homeMsp, "fadmin")
+	alice := newIdentity(t, homeMsp, "alice")
+
+	coins := new(coinsMock)
+
+	stub := shimtest.NewMockStub("foundation", new(FoundationChain))
+	stub.MockPeerChaincode(coinsChaincode, shimtest.NewMockStub(coinsChaincode, coins), channelName)
+	stub.Creator = fadmin
+
+	invoke := func(caller []byte, args ...string) pb.Response {
+		stub.Creator = caller
+		byteArgs := make([][]byte, len(args))
+		for i, arg := range args {
+			byteArgs[i] = []byte(arg)
+		}
+		return stub.MockInvoke(args[0], byteArgs)
+	}
+
+	stub.MockInit("init", [][]byte{[]byte("init")})
+	invoke(fadmin, "createFoundation", "f1", "fadmin", "creator", "100", "60", "false", "true", "SJ", "SJ")
+	invoke(alice, "donate", "SJ", "30", "f1")
+	invoke(alice, "donate", "SJ", "2.5", "f1")
+	coins.calls = nil
+
+	response := invoke(fadmin, "close", "f1")
+	if response.Status != shim.OK {
+		t.Fatalf("close failed: %s", response.Message)
+	}
+
+	want := [][]string{{"TransferFrom", "SJ", "foundation_", "f1", "user_", "alice", "32.5"}}
+	if !reflect.DeepEqual(coins.calls, want) {
+		t.Fatalf("expected refunds %v, got %v", want, coins.calls)
+	}
+}
+
+// An upgrade by a peer admin of another organization keeps the home MSP, so
+// users of the home MSP keep their IDs.
+func TestFoundationUpgrade(t *testing.T) {
+	fadmin := newIdentity(t, homeMsp, "fadmin")
+	partner := newIdentity(t, "Org2MSP", "admin")
+
+	stub := shimtest.NewMockStub("foundation", new(FoundationChain))
+	stub.Creator = fadmin
+	stub.MockInit("init", [][]byte{[]byte("init")})
+	stub.MockInvoke("create", [][]byte{[]byte("createFoundation"), []byte("f1"), []byte("fadmin"), []byte("creator"), []byte("100"), []byte("60"), []byte("false"), []byte("true"), []byte("SJ"), []byte("SJ")})
+
+	stub.Creator = partner
+	response := stub.MockInit("upgrade", [][]byte{[]byte("init")})
+	if response.Status != shim.OK {
+		t.Fatalf("upgrade failed: %s", response.Message)
+	}
+
+	stub.Creator = fadmin
+	response = stub.MockInvoke("close", [][]byte{[]byte("close"), []byte("f1")})
+	if response.Status != shim.OK {
+		t.Fatalf("close failed: %s", response.Message)
+	}
+}
+
+// newIdentity returns a serialized identity of the MSP with a self-signed
+// certificate of the common name.
+func newIdentity(t *testing.T, mspId string, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return identity
+}
@@ -0,0 +1,3 @@
+module github.com/helper
+
+go 1.13
@@ -1,16 +1,87 @@
 package helper
 
 import (
-	"strconv"
+	"fmt"
+	"math/big"
+	"strings"
 )
 
+// MaxDecimals is the highest number of decimals a currency can have.
+const MaxDecimals = 18
+
 func init() {
 }
 
-func ParseAmountUint(amount string) uint {
-	amount32, err := strconv.ParseUint(amount, 10, 32)
-	if err != nil {
-		return 0
+// ParseAmount converts a decimal amount like "12.5" into base units of a
+// currency with the given number of decimals (1250 for 2 decimals). Signs,
+// exponents and more fractional digits than decimals are rejected.
+func ParseAmount(amount string, decimals int) (*big.Int, error) {
+	if decimals < 0 || decimals > MaxDecimals {
+		return nil, fmt.Errorf("incorrect decimals %d", decimals)
+	}
+
+	integerPart := amount
+	fractionalPart := ""
+
+	if index := strings.Index(amount, "."); index != -1 {
+		integerPart = amount[:index]
+		fractionalPart = amount[index+1:]
+
+		if len(fractionalPart) == 0 {
+			return nil, fmt.Errorf("incorrect amount %q", amount)
+		}
+	}
+
+	if len(integerPart) == 0 || !isDigits(integerPart) || !isDigits(fractionalPart) {
+		return nil, fmt.Errorf("incorrect amount %q", amount)
+	}
+
+	fractionalPart = strings.TrimRight(fractionalPart, "0")
+	if len(fractionalPart) > decimals {
+		return nil, fmt.Errorf("amount %q has more than %d decimals", amount, decimals)
+	}
+
+	value, ok := new(big.Int).SetString(integerPart+fractionalPart+strings.Repeat("0", decimals-len(fractionalPart)), 10)
+	if !ok {
+		return nil, fmt.Errorf("incorrect amount %q", amount)
+	}
+
+	return value, nil
+}
+
+// FormatAmount converts base units into the canonical decimal form of the
+// amount: no leading zeros and no trailing fractional zeros ("12.5", "3").
+func FormatAmount(amount *big.Int, decimals int) string {
+	digits := new(big.Int).Abs(amount).String()
+
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+
+	if decimals <= 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	integerPart := digits[:len(digits)-decimals]
+	fractionalPart := strings.TrimRight(digits[len(digits)-decimals:], "0")
+
+	if len(fractionalPart) == 0 {
+		return sign + integerPart
+	}
+
+	return sign + integerPart + "." + fractionalPart
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
-	return uint(amount32)
+	return true
 }
@@ -0,0 +1,8 @@
+module github.com/users
+
+go 1.13
+
+require (
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20200128192331-2d899240a7ed
+	github.com/hyperledger/fabric-protos-go v0.0.0-20200124220212-e9cfc186ba7b
+)
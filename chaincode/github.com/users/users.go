@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"log"
+	"os"
 )
 
-var logger *shim.ChaincodeLogger
+var logger = log.New(os.Stdout, "users ", log.LstdFlags)
 
 var orgId string
 var usersKey = "users"
@@ -37,8 +39,8 @@ func (t *UsersChain) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 	orgId = args[0]
 
-	logger = shim.NewLogger(orgId)
-	logger.Infof("orgId: %s", orgId)
+	logger.SetPrefix(orgId + " ")
+	logger.Printf("orgId: %s", orgId)
 
 	users = t.getUsers(stub)
 	if users == nil {
@@ -52,20 +54,20 @@ func (t *UsersChain) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *UsersChain) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 
 	function, args := stub.GetFunctionAndParameters()
-	logger.Info("invoke is running " + function)
+	logger.Println("invoke is running " + function)
 
 	if function == "addUser" {
 		return t.addUser(stub, args)
 	} else if function == "getUserDataById" {
 		return t.getUserDataById(stub, args)
 	}
-	logger.Info("invoke did not find func: " + function)
+	logger.Println("invoke did not find func: " + function)
 	return shim.Error("Received unknown function invocation")
 
 }
 
 func (t *UsersChain) addUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Infof("args: %v", args)
+	logger.Printf("args: %v", args)
 
 	if len(args) != 1 || len(args[0]) == 0 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
@@ -74,10 +76,15 @@ func (t *UsersChain) addUser(stub shim.ChaincodeStubInterface, args []string) pb
 	var userData UserData
 	err := json.Unmarshal([]byte(args[0]), &userData)
 	if err != nil {
-		logger.Errorf("\nerr: %v\n", err)
+		logger.Printf("\nerr: %v\n", err)
 		return shim.Error(err.Error())
 	}
-	logger.Infof("\nuserData: %v\n", userData)
+	logger.Printf("\nuserData: %v\n", userData)
+
+	users = t.getUsers(stub)
+	if users == nil {
+		users = make(map[string]UserData)
+	}
 
 	users[userData.Email] = userData
 	t.saveUsers(stub, users)
@@ -86,28 +93,31 @@ func (t *UsersChain) addUser(stub shim.ChaincodeStubInterface, args []string) pb
 }
 
 func (t *UsersChain) getUserDataById(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	logger.Infof("args: %v", args)
+	logger.Printf("args: %v", args)
 
 	if len(args) != 1 || len(args[0]) == 0 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
+	// Read users from the ledger, the package map is empty after a peer restart
+	users = t.getUsers(stub)
+
 	if value, ok := users[args[0]]; ok {
 		userBytes, err := json.Marshal(value)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		logger.Infof("userBytes: %s", userBytes)
+		logger.Printf("userBytes: %s", userBytes)
 		return shim.Success(userBytes)
 	} else {
-		logger.Errorf("Error: %v", "User not found")
+		logger.Printf("Error: %v", "User not found")
 		return shim.Error("User not found")
 	}
 }
 
 func (t *UsersChain) getUsers(stub shim.ChaincodeStubInterface) map[string]UserData {
 
-	logger.Info("------ getMap called")
+	logger.Println("------ getMap called")
 	mapBytes, err := stub.GetState(usersKey)
 	if err != nil {
 		return nil
@@ -118,12 +128,12 @@ func (t *UsersChain) getUsers(stub shim.ChaincodeStubInterface) map[string]UserD
 	if err != nil {
 		return nil
 	}
-	logger.Info("received map", mapObject)
+	logger.Println("received map", mapObject)
 	return mapObject
 }
 
 func (t *UsersChain) saveUsers(stub shim.ChaincodeStubInterface, mapObject map[string]UserData) pb.Response {
-	logger.Info("------ saveUsers called")
+	logger.Println("------ saveUsers called")
 	userData, err := json.Marshal(mapObject)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -132,13 +142,13 @@ func (t *UsersChain) saveUsers(stub shim.ChaincodeStubInterface, mapObject map[s
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	logger.Info("saved ", mapObject)
+	logger.Println("saved ", mapObject)
 	return shim.Success(nil)
 }
 
 func main() {
 	err := shim.Start(new(UsersChain))
 	if err != nil {
-		logger.Errorf("Error starting Cinchain: %s", err)
+		logger.Printf("Error starting Cinchain: %s", err)
 	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+type usersCase struct {
+	name     string
+	function string
+	args     []string
+	err      string // expected error message prefix, empty for success
+	want     string // expected payload, checked if not empty
+	before   func() // runs before the invocation
+}
+
+func TestUsers(t *testing.T) {
+	stub := shimtest.NewMockStub("users", new(UsersChain))
+
+	response := stub.MockInit("init", [][]byte{[]byte("init"), []byte("org1")})
+	if response.Status != shim.OK {
+		t.Fatalf("init failed: %s", response.Message)
+	}
+
+	alice, err := json.Marshal(UserData{Email: "alice", FirstName: "Alice", LastName: "Smith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []usersCase{
+		{name: "add without arguments", function: "addUser", err: "Incorrect number of arguments"},
+		{name: "add malformed", function: "addUser", args: []string{"{"}, err: "unexpected end of JSON input"},
+		{name: "add", function: "addUser", args: []string{string(alice)}},
+		{name: "get", function: "getUserDataById", args: []string{"alice"}, want: string(alice)},
+		{name: "get without arguments", function: "getUserDataById", err: "Incorrect number of arguments"},
+		{name: "get unknown", function: "getUserDataById", args: []string{"bob"}, err: "User not found"},
+		// A restarted peer starts with an empty package map, users come from the ledger
+		{name: "get after restart", function: "getUserDataById", args: []string{"alice"}, want: string(alice), before: func() { users = nil }},
+		{name: "unknown function", function: "deleteUser", args: []string{"alice"}, err: "Received unknown function invocation"},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.before != nil {
+				tc.before()
+			}
+
+			args := [][]byte{[]byte(tc.function)}
+			for _, arg := range tc.args {
+				args = append(args, []byte(arg))
+			}
+
+			response := stub.MockInvoke(fmt.Sprintf("tx%d", i), args)
+
+			if len(tc.err) != 0 {
+				if response.Status == shim.OK {
+					t.Fatalf("expected error %q, got success", tc.err)
+				}
+				if !strings.HasPrefix(response.Message, tc.err) {
+					t.Fatalf("expected error %q, got %q", tc.err, response.Message)
+				}
+				return
+			}
+
+			if response.Status != shim.OK {
+				t.Fatalf("%s failed: %s", tc.function, response.Message)
+			}
+
+			if len(tc.want) != 0 && string(response.Payload) != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, response.Payload)
+			}
+		})
+	}
+}
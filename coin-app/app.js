@@ -155,7 +155,10 @@ app.post('/invoke', async function (req, res) {
         return;
     }
 
-    let message = await chaincodeActions.invoke(req.username, fcn, args, isObject);
+    let message = await chaincodeActions.invoke(req.username, fcn, args, isObject, req.body.requestId, req.body.currency, req.body.projectId);
+    if (!message.success) {
+        res.statusCode = chaincodeActions.getHttpStatus(message.code);
+    }
     res.send(message);
 });
 
@@ -179,6 +182,9 @@ app.post('/query', async function (req, res) {
         return;
     }
 
-    let message = await chaincodeActions.query(req.username, fcn, args, isObject);
+    let message = await chaincodeActions.query(req.username, fcn, args, isObject, req.body.currency);
+    if (!message.success) {
+        res.statusCode = chaincodeActions.getHttpStatus(message.code);
+    }
     res.send(message);
 });
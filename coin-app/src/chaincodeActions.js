@@ -5,7 +5,7 @@ const fs = require('fs');
 const path = require('path');
 const config = require('../config.json')
 
-async function invoke(user, fcn, args, isObject) {
+async function invoke(user, fcn, args, isObject, requestId, currency, projectId) {
     try {
         const gateway = await loadGateway(user);
         // Get the network (channel) our contract is deployed to.
@@ -17,7 +17,7 @@ async function invoke(user, fcn, args, isObject) {
         let result;
 
         if (isObject) {
-            result = await transaction.submit(args);
+            result = await transaction.submit(...objectArgs(fcn, args, currency, requestId, projectId));
         } else {
             result = await transaction.submit(...args);
         }
@@ -39,12 +39,13 @@ async function invoke(user, fcn, args, isObject) {
         console.error(`Failed to submit transaction: ${error}`);
         return {
             success: false,
+            code: getErrorCode(error),
             message: `Error: ${error}`
         };
     }
 }
 
-async function query(user, fcn, args, isObject) {
+async function query(user, fcn, args, isObject, currency) {
     try {
         const gateway = await loadGateway(user);
         // Get the network (channel) our contract is deployed to.
@@ -56,7 +57,7 @@ async function query(user, fcn, args, isObject) {
         let result;
 
         if (isObject) {
-            result = await transaction.evaluate(args);
+            result = await transaction.evaluate(...objectArgs(fcn, args, currency));
         } else {
             result = await transaction.evaluate(...args);
         }
@@ -77,11 +78,56 @@ async function query(user, fcn, args, isObject) {
     } catch (error) {
         return {
             success: false,
+            code: getErrorCode(error),
             message: `Error: ${error}`
         };
     }
 }
 
+// Arguments of methods taking a JSON object. Every one takes the currency
+// first, an empty currency means the default one.
+function objectArgs(fcn, object, currency, requestId, projectId) {
+    switch (fcn) {
+        case 'BatchTransfer':
+            return [currency || '', object, requestId || ''];
+        case 'BatchRefund':
+            return [currency || '', projectId || '', object];
+        default:
+            return [currency || '', object];
+    }
+}
+
+// Chaincode errors look like "<CODE>: <message>", see coins/validation.go
+const errorCodes = {
+    INVALID_ARGUMENT: 400,
+    INVALID_AMOUNT: 400,
+    INVALID_RECEIVER: 400,
+    DUPLICATE_RECEIVER: 400,
+    INVALID_IDENTITY: 401,
+    NO_PERMISSIONS: 403,
+    ACCOUNT_FROZEN: 403,
+    NOT_FOUND: 404,
+    INSUFFICIENT_FUNDS: 409,
+    INSUFFICIENT_ALLOWANCE: 409,
+    INVALID_STATE: 409,
+    DUPLICATE_REQUEST: 409,
+    LIMIT_EXCEEDED: 409,
+    SLIPPAGE: 409,
+    PAUSED: 503
+};
+
+function getErrorCode(error) {
+    const match = /\b([A-Z_]+): /.exec(`${error}`);
+    if (match && errorCodes[match[1]]) {
+        return match[1];
+    }
+    return undefined;
+}
+
+function getHttpStatus(code) {
+    return errorCodes[code] || 500;
+}
+
 async function loadGateway(user) {
     // Load the network configuration
     const ccpPath = path.resolve(process.cwd(), 'connection-profile.json');
@@ -106,3 +152,4 @@ async function loadGateway(user) {
 
 exports.invoke = invoke;
 exports.query = query;
+exports.getHttpStatus = getHttpStatus;